@@ -5,6 +5,13 @@ type ActionContext struct {
 	TeamID string `json:"team_id"`
 	UserID string `json:"user_id"`
 	Action string `json:"action"`
+
+	// The channel of the welcome message, for the buttons of the channel welcome messages
+	ChannelID string `json:"channel_id,omitempty"`
+
+	// The welcome message and its variant the button was posted with, missing from older posts
+	MessageID string `json:"message_id,omitempty"`
+	Variant   string `json:"variant,omitempty"`
 }
 
 // Action type for decoding action buttons
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const apiPathPrefix = "/api/v1/"
+
+// APIError is the body of the error responses of the REST API
+type APIError struct {
+	Error string `json:"error"`
+}
+
+// TeamWelcome is the representation of a team welcome message in the REST API, in the format of
+// config.json along with where the message is defined
+type TeamWelcome struct {
+	*ConfigMessage
+	Source string
+}
+
+// ChannelWelcome is the representation of a channel welcome message in the REST API
+type ChannelWelcome struct {
+	ChannelID              string                 `json:"channel_id"`
+	Message                string                 `json:"message"`
+	AttachmentMessage      string                 `json:"attachment_message,omitempty"`
+	Actions                []*ConfigMessageAction `json:"actions,omitempty"`
+	DeliveryMode           string                 `json:"delivery_mode,omitempty"`
+	DelayInSeconds         int                    `json:"delay_in_seconds,omitempty"`
+	RejoinPolicy           string                 `json:"rejoin_policy,omitempty"`
+	RejoinAfterDays        int                    `json:"rejoin_after_days,omitempty"`
+	Translations           map[string]string      `json:"translations,omitempty"`
+	AttachmentTranslations map[string]string      `json:"attachment_translations,omitempty"`
+}
+
+func newChannelWelcome(channelID string, welcome *ChannelWelcomeRecord, translations map[string]*ChannelWelcomeTranslation) *ChannelWelcome {
+	messages, attachmentMessages := splitChannelWelcomeTranslations(translations)
+	return &ChannelWelcome{
+		ChannelID:              channelID,
+		Message:                welcome.Message,
+		AttachmentMessage:      welcome.AttachmentMessage,
+		Actions:                welcome.Actions,
+		DeliveryMode:           welcome.DeliveryMode,
+		DelayInSeconds:         welcome.DelayInSeconds,
+		RejoinPolicy:           welcome.RejoinPolicy,
+		RejoinAfterDays:        welcome.RejoinAfterDays,
+		Translations:           messages,
+		AttachmentTranslations: attachmentMessages,
+	}
+}
+
+func (w *ChannelWelcome) record() *ChannelWelcomeRecord {
+	return &ChannelWelcomeRecord{
+		Message:           w.Message,
+		AttachmentMessage: w.AttachmentMessage,
+		Actions:           w.Actions,
+		DeliveryMode:      w.DeliveryMode,
+		DelayInSeconds:    w.DelayInSeconds,
+		RejoinPolicy:      w.RejoinPolicy,
+		RejoinAfterDays:   w.RejoinAfterDays,
+	}
+}
+
+// PreviewRequest asks for the rendering of a team welcome message. Either a stored message is
+// referenced by its ID, or a message is given inline.
+type PreviewRequest struct {
+	TeamWelcomeID string         `json:"team_welcome_id"`
+	TeamWelcome   *ConfigMessage `json:"team_welcome"`
+
+	// The user to render the message for. Defaults to the user making the request.
+	UserID string `json:"user_id"`
+}
+
+// serveAPI routes the requests of the REST API. All the endpoints require an authenticated user,
+// permissions are then checked per resource.
+func (p *Plugin) serveAPI(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		p.writeAPIError(w, http.StatusUnauthorized, "not authenticated")
+		return
+	}
+
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, apiPathPrefix), "/"), "/")
+	switch {
+	case segments[0] == "team_welcomes" && len(segments) == 1:
+		p.serveTeamWelcomes(w, r, userID)
+	case segments[0] == "team_welcomes" && len(segments) == 2:
+		p.serveTeamWelcome(w, r, userID, segments[1])
+	case segments[0] == "channel_welcomes" && len(segments) == 1:
+		p.serveChannelWelcomes(w, r, userID)
+	case segments[0] == "channel_welcomes" && len(segments) == 2:
+		p.serveChannelWelcome(w, r, userID, segments[1])
+	case segments[0] == "preview" && len(segments) == 1:
+		p.servePreview(w, r, userID)
+	case segments[0] == "stats" && len(segments) == 1:
+		p.serveStats(w, r, userID)
+	default:
+		p.writeAPIError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (p *Plugin) writeAPIResponse(w http.ResponseWriter, statusCode int, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		p.API.LogWarn("failed to write API response", "error", err.Error())
+	}
+}
+
+func (p *Plugin) writeAPIError(w http.ResponseWriter, statusCode int, message string) {
+	p.writeAPIResponse(w, statusCode, &APIError{Error: message})
+}
+
+func (p *Plugin) isSysadmin(userID string) bool {
+	isSysadmin, err := p.hasSysadminRole(userID)
+	if err != nil {
+		p.API.LogError("failed to check permissions", "user_id", userID, "error", err.Error())
+		return false
+	}
+
+	return isSysadmin
+}
+
+// checkTeamWelcome makes sure a team welcome message received through the API can be stored by
+// the user. It returns the status code and message of the error, if any.
+func (p *Plugin) checkTeamWelcome(userID string, message *ConfigMessage) (int, string) {
+	if message.TeamName == "" {
+		return http.StatusBadRequest, "the team name is required"
+	}
+	if message.isEmpty() {
+		return http.StatusBadRequest, "a message, an attachment message, actions, steps or variants are required"
+	}
+	if _, appErr := p.API.GetTeamByName(message.TeamName); appErr != nil {
+		return http.StatusBadRequest, "the team has not been found"
+	}
+	if !p.canManageTeamWelcome(userID, message.TeamName) {
+		return http.StatusForbidden, "only system admins and admins of the team can manage its welcome messages"
+	}
+	problems := p.checkWelcomeMessage(message)
+	problems = append(problems, checkActionNamesInTeam(message, p.getWelcomeMessages())...)
+	if len(problems) > 0 {
+		return http.StatusBadRequest, strings.Join(problems, "; ")
+	}
+
+	return 0, ""
+}
+
+// serveTeamWelcomes lists the team welcome messages the user can manage, or creates one.
+func (p *Plugin) serveTeamWelcomes(w http.ResponseWriter, r *http.Request, userID string) {
+	switch r.Method {
+	case http.MethodGet:
+		messages := make([]*TeamWelcome, 0)
+		for _, message := range p.getWelcomeMessages() {
+			if p.canManageTeamWelcome(userID, message.TeamName) {
+				messages = append(messages, &TeamWelcome{ConfigMessage: message, Source: message.Source})
+			}
+		}
+		p.writeAPIResponse(w, http.StatusOK, messages)
+	case http.MethodPost:
+		var message *ConfigMessage
+		if err := json.NewDecoder(r.Body).Decode(&message); err != nil || message == nil {
+			p.writeAPIError(w, http.StatusBadRequest, "invalid team welcome message")
+			return
+		}
+
+		message.ID = model.NewId()
+		message.TeamName = strings.ToLower(message.TeamName)
+		if statusCode, errMessage := p.checkTeamWelcome(userID, message); statusCode != 0 {
+			p.writeAPIError(w, statusCode, errMessage)
+			return
+		}
+
+		if err := p.saveStoredWelcomeMessage(message); err != nil {
+			p.API.LogError("failed to save stored welcome message", "id", message.ID, "error", err.Error())
+			p.writeAPIError(w, http.StatusInternalServerError, "failed to save the team welcome message")
+			return
+		}
+
+		p.writeAPIResponse(w, http.StatusCreated, &TeamWelcome{ConfigMessage: message, Source: messageSourceStore})
+	default:
+		p.writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// serveTeamWelcome reads, replaces or deletes a team welcome message. Messages defined in
+// config.json are read-only.
+func (p *Plugin) serveTeamWelcome(w http.ResponseWriter, r *http.Request, userID, id string) {
+	existing := p.getWelcomeMessageByID(id)
+	if existing == nil {
+		p.writeAPIError(w, http.StatusNotFound, "team welcome message not found")
+		return
+	}
+	if !p.canManageTeamWelcome(userID, existing.TeamName) {
+		p.writeAPIError(w, http.StatusForbidden, "only system admins and admins of the team can manage its welcome messages")
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		p.writeAPIResponse(w, http.StatusOK, &TeamWelcome{ConfigMessage: existing, Source: existing.Source})
+		return
+	}
+	if r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		p.writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if existing.Source != messageSourceStore {
+		p.writeAPIError(w, http.StatusConflict, "the team welcome message is defined in config.json and can only be changed there")
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		if _, err := p.deleteStoredWelcomeMessage(id); err != nil {
+			p.API.LogError("failed to delete stored welcome message", "id", id, "error", err.Error())
+			p.writeAPIError(w, http.StatusInternalServerError, "failed to delete the team welcome message")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var message *ConfigMessage
+	if err := json.NewDecoder(r.Body).Decode(&message); err != nil || message == nil {
+		p.writeAPIError(w, http.StatusBadRequest, "invalid team welcome message")
+		return
+	}
+
+	message.ID = id
+	message.TeamName = strings.ToLower(message.TeamName)
+	if statusCode, errMessage := p.checkTeamWelcome(userID, message); statusCode != 0 {
+		p.writeAPIError(w, statusCode, errMessage)
+		return
+	}
+
+	if err := p.saveStoredWelcomeMessage(message); err != nil {
+		p.API.LogError("failed to save stored welcome message", "id", id, "error", err.Error())
+		p.writeAPIError(w, http.StatusInternalServerError, "failed to save the team welcome message")
+		return
+	}
+
+	p.writeAPIResponse(w, http.StatusOK, &TeamWelcome{ConfigMessage: message, Source: messageSourceStore})
+}
+
+// serveChannelWelcomes lists the welcome messages of all the channels. It is restricted to system
+// admins.
+func (p *Plugin) serveChannelWelcomes(w http.ResponseWriter, r *http.Request, userID string) {
+	if r.Method != http.MethodGet {
+		p.writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !p.isSysadmin(userID) {
+		p.writeAPIError(w, http.StatusForbidden, "only system admins can list all the channel welcome messages")
+		return
+	}
+
+	welcomes, err := p.listChannelWelcomes()
+	if err != nil {
+		p.API.LogError("failed to list channel welcome messages", "error", err.Error())
+		p.writeAPIError(w, http.StatusInternalServerError, "failed to list the channel welcome messages")
+		return
+	}
+
+	response := make([]*ChannelWelcome, 0, len(welcomes))
+	for channelID, welcome := range welcomes {
+		translations, err := p.getChannelWelcomeTranslations(channelID)
+		if err != nil {
+			p.API.LogError("failed to get channel welcome message translations", "channel_id", channelID, "error", err.Error())
+			p.writeAPIError(w, http.StatusInternalServerError, "failed to list the channel welcome messages")
+			return
+		}
+		response = append(response, newChannelWelcome(channelID, welcome, translations))
+	}
+	p.writeAPIResponse(w, http.StatusOK, response)
+}
+
+// serveChannelWelcome reads, sets or deletes the welcome message of a channel. Like the slash
+// commands, it requires the permission to manage the roles of the channel.
+func (p *Plugin) serveChannelWelcome(w http.ResponseWriter, r *http.Request, userID, channelID string) {
+	if !model.IsValidId(channelID) {
+		p.writeAPIError(w, http.StatusBadRequest, "invalid channel ID")
+		return
+	}
+
+	channel, appErr := p.API.GetChannel(channelID)
+	if appErr != nil {
+		p.writeAPIError(w, http.StatusNotFound, "channel not found")
+		return
+	}
+	if !p.isSysadmin(userID) && !p.API.HasPermissionToChannel(userID, channelID, model.PermissionManageChannelRoles) {
+		p.writeAPIError(w, http.StatusForbidden, "only system admins and channel admins can manage the welcome message of the channel")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		welcome, err := p.getChannelWelcome(channelID)
+		if err != nil {
+			p.API.LogError("failed to get channel welcome message", "channel_id", channelID, "error", err.Error())
+			p.writeAPIError(w, http.StatusInternalServerError, "failed to get the channel welcome message")
+			return
+		}
+		if welcome == nil {
+			p.writeAPIError(w, http.StatusNotFound, "channel welcome message not found")
+			return
+		}
+		translations, err := p.getChannelWelcomeTranslations(channelID)
+		if err != nil {
+			p.API.LogError("failed to get channel welcome message translations", "channel_id", channelID, "error", err.Error())
+			p.writeAPIError(w, http.StatusInternalServerError, "failed to get the channel welcome message")
+			return
+		}
+		p.writeAPIResponse(w, http.StatusOK, newChannelWelcome(channelID, welcome, translations))
+	case http.MethodPut:
+		if err := p.checkChannelWelcomeSupported(channel); err != nil {
+			p.writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		var welcome *ChannelWelcome
+		if err := json.NewDecoder(r.Body).Decode(&welcome); err != nil || welcome == nil || strings.TrimSpace(welcome.Message) == "" {
+			p.writeAPIError(w, http.StatusBadRequest, "invalid channel welcome message")
+			return
+		}
+
+		welcome.ChannelID = channelID
+		welcome.Message = strings.TrimSpace(welcome.Message)
+		welcome.AttachmentMessage = strings.TrimSpace(welcome.AttachmentMessage)
+		translations := newChannelWelcomeTranslations(welcome.Translations, welcome.AttachmentTranslations)
+		welcome.Translations, welcome.AttachmentTranslations = splitChannelWelcomeTranslations(translations)
+		record := welcome.record()
+		if err := p.checkChannelWelcome(channel, record, translations); err != nil {
+			p.writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := p.checkChannelWelcomeActionsAllowed(userID, channel, record); err != nil {
+			p.writeAPIError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		record.SetBy = userID
+		if err := p.setChannelWelcome(channelID, record); err != nil {
+			p.API.LogError("failed to set channel welcome message", "channel_id", channelID, "error", err.Error())
+			p.writeAPIError(w, http.StatusInternalServerError, "failed to save the channel welcome message")
+			return
+		}
+		if err := p.setChannelWelcomeTranslations(channelID, translations); err != nil {
+			p.API.LogError("failed to set channel welcome message translations", "channel_id", channelID, "error", err.Error())
+			p.writeAPIError(w, http.StatusInternalServerError, "failed to save the channel welcome message")
+			return
+		}
+		p.writeAPIResponse(w, http.StatusOK, welcome)
+	case http.MethodDelete:
+		if err := p.deleteChannelWelcome(channelID); err != nil {
+			p.API.LogError("failed to delete channel welcome message", "channel_id", channelID, "error", err.Error())
+			p.writeAPIError(w, http.StatusInternalServerError, "failed to delete the channel welcome message")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		p.writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// servePreview renders a team welcome message without sending it. Automatic actions are not run.
+func (p *Plugin) servePreview(w http.ResponseWriter, r *http.Request, userID string) {
+	if r.Method != http.MethodPost {
+		p.writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var request *PreviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request == nil {
+		p.writeAPIError(w, http.StatusBadRequest, "invalid preview request")
+		return
+	}
+
+	message := request.TeamWelcome
+	if request.TeamWelcomeID != "" {
+		message = p.getWelcomeMessageByID(request.TeamWelcomeID)
+		if message == nil {
+			p.writeAPIError(w, http.StatusNotFound, "team welcome message not found")
+			return
+		}
+	}
+	if message == nil {
+		p.writeAPIError(w, http.StatusBadRequest, "either team_welcome_id or team_welcome is required")
+		return
+	}
+	if !p.canManageTeamWelcome(userID, message.TeamName) {
+		p.writeAPIError(w, http.StatusForbidden, "only system admins and admins of the team can preview its welcome messages")
+		return
+	}
+
+	// Templates can read the profile of the user, so previewing for another user is restricted to
+	// system admins, and to the members of the team of the message
+	previewUserID := userID
+	if request.UserID != "" && request.UserID != userID {
+		if !p.isSysadmin(userID) {
+			p.writeAPIError(w, http.StatusForbidden, "only system admins can preview welcome messages for other users")
+			return
+		}
+		team, appErr := p.API.GetTeamByName(message.TeamName)
+		if appErr != nil {
+			p.writeAPIError(w, http.StatusBadRequest, "team not found")
+			return
+		}
+		if member, appErr := p.API.GetTeamMember(team.Id, request.UserID); appErr != nil || member.DeleteAt > 0 {
+			p.writeAPIError(w, http.StatusBadRequest, "the user is not a member of the team of the welcome message")
+			return
+		}
+		previewUserID = request.UserID
+	}
+
+	data, err := p.newSampleMessageTemplate(message.TeamName, previewUserID)
+	if err != nil {
+		p.writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	posts := make([]*model.Post, 0)
+	for _, variantMessage := range message.getVariantMessages() {
+		for _, step := range variantMessage.getSteps() {
+			stepMessage := variantMessage.forStep(step).localized(p.getLocaleFallbacks(data.User))
+			posts = append(posts, p.renderWelcomeMessage(*data, stepMessage.withoutAutomaticActions()))
+		}
+	}
+	p.writeAPIResponse(w, http.StatusOK, posts)
+}
+
+// serveStats returns the onboarding statistics of a team, or of all the teams for system admins,
+// as JSON or CSV.
+func (p *Plugin) serveStats(w http.ResponseWriter, r *http.Request, userID string) {
+	if r.Method != http.MethodGet {
+		p.writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	since, err := parseStatsSince(query.Get("since"))
+	if err != nil {
+		p.writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	format := query.Get("format")
+	if format == "" {
+		format = statsFormatJSON
+	}
+	if format != statsFormatJSON && format != statsFormatCSV {
+		p.writeAPIError(w, http.StatusBadRequest, "the format must be json or csv")
+		return
+	}
+
+	var team *model.Team
+	if teamName := query.Get("team"); teamName != "" {
+		var appErr *model.AppError
+		if team, appErr = p.API.GetTeamByName(strings.ToLower(teamName)); appErr != nil {
+			p.writeAPIError(w, http.StatusNotFound, "the team has not been found")
+			return
+		}
+		if !p.canManageTeamWelcome(userID, team.Name) {
+			p.writeAPIError(w, http.StatusForbidden, "only system admins and admins of the team can see its statistics")
+			return
+		}
+	} else if !p.isSysadmin(userID) {
+		p.writeAPIError(w, http.StatusForbidden, "only system admins can see the statistics of all the teams")
+		return
+	}
+
+	report, err := p.getStatsReport(team, since)
+	if err != nil {
+		p.API.LogError("failed to get the statistics", "err", err.Error())
+		p.writeAPIError(w, http.StatusInternalServerError, "failed to get the statistics")
+		return
+	}
+
+	if format == statsFormatJSON {
+		p.writeAPIResponse(w, http.StatusOK, report)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="welcomebot-stats.csv"`)
+	if err := writeStatsCSV(w, report); err != nil {
+		p.API.LogWarn("failed to write the statistics", "error", err.Error())
+	}
+}
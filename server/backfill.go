@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
+	"github.com/pkg/errors"
+)
+
+const (
+	backfillMembersPerPage        = 100
+	defaultBackfillUsersPerMinute = 30
+	maxBackfillUsersPerMinute     = 600
+
+	flagJoinedAfter   = "--joined-after"
+	flagJoinedBefore  = "--joined-before"
+	flagExcludeGuests = "--exclude-guests"
+	flagRate          = "--rate"
+	flagDryRun        = "--dry-run"
+
+	backfillDateLayout = "2006-01-02"
+
+	// Key of the cluster mutex held while a team is backfilled, by team ID
+	backfillLockKey = "backfill_"
+
+	// How long to try to lock a team before considering that it is already being backfilled
+	backfillLockTimeout = time.Second
+)
+
+var errBackfillStopped = errors.New("the plugin has been stopped")
+
+// backfillOptions select the members of a team to welcome, and how fast
+type backfillOptions struct {
+	// Only members who joined the team within these bounds, if set
+	JoinedAfter  time.Time
+	JoinedBefore time.Time
+
+	ExcludeGuests  bool
+	UsersPerMinute int
+
+	// Only count the members who would be welcomed
+	DryRun bool
+}
+
+// backfillSummary counts the members processed by a backfill
+type backfillSummary struct {
+	Members  int
+	Welcomed int
+	Skipped  int
+	Failed   int
+}
+
+func parseBackfillOptions(flags map[string]string) (*backfillOptions, error) {
+	options := &backfillOptions{
+		UsersPerMinute: defaultBackfillUsersPerMinute,
+	}
+
+	for name, value := range flags {
+		var err error
+		switch name {
+		case flagJoinedAfter:
+			options.JoinedAfter, err = time.Parse(backfillDateLayout, value)
+		case flagJoinedBefore:
+			options.JoinedBefore, err = time.Parse(backfillDateLayout, value)
+		case flagExcludeGuests:
+			options.ExcludeGuests = true
+		case flagDryRun:
+			options.DryRun = true
+		case flagRate:
+			options.UsersPerMinute, err = strconv.Atoi(value)
+			if err == nil && options.UsersPerMinute <= 0 {
+				err = errors.New("must be a positive number")
+			} else if err == nil && options.UsersPerMinute > maxBackfillUsersPerMinute {
+				err = errors.Errorf("must be at most %d", maxBackfillUsersPerMinute)
+			}
+		default:
+			return nil, errors.Errorf("unknown option `%s`", name)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid value for `%s`", name)
+		}
+	}
+
+	return options, nil
+}
+
+// getJoinTime returns the time the member joined the team. Servers which don't report it fall
+// back to the creation time of the account, which is never later.
+func getJoinTime(member *model.TeamMember, user *model.User) time.Time {
+	if member.CreateAt > 0 {
+		return time.UnixMilli(member.CreateAt)
+	}
+
+	return time.UnixMilli(user.CreateAt)
+}
+
+// startBackfill starts the backfill of a team in the background. The team is locked across the
+// cluster while it is backfilled, and false is returned if it is already being backfilled.
+func (p *Plugin) startBackfill(team *model.Team, options *backfillOptions, args *model.CommandArgs) (bool, error) {
+	mutex, err := cluster.NewMutex(p.API, backfillLockKey+team.Id)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to create the backfill lock")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), backfillLockTimeout)
+	defer cancel()
+	if err := mutex.LockWithContext(ctx); err != nil {
+		return false, nil
+	}
+
+	p.postCommandResponse(args, "Backfill of team `%s` started, welcoming up to %d members per minute.", team.Name, options.UsersPerMinute)
+	p.backfills.Add(1)
+	go func() {
+		defer p.backfills.Done()
+		defer mutex.Unlock()
+		p.backfillTeam(team, options, args)
+	}()
+
+	return true, nil
+}
+
+// backfillTeam welcomes the existing members of a team who never received its welcome messages.
+// It reports its progress to the admin who started it, and stops when the plugin is deactivated.
+func (p *Plugin) backfillTeam(team *model.Team, options *backfillOptions, args *model.CommandArgs) {
+
+	ticker := time.NewTicker(time.Minute / time.Duration(options.UsersPerMinute))
+	defer ticker.Stop()
+
+	summary := &backfillSummary{}
+	for page := 0; ; page++ {
+		members, appErr := p.API.GetTeamMembers(team.Id, page, backfillMembersPerPage)
+		if appErr != nil {
+			p.API.LogError("failed to get team members", "team_id", team.Id, "page", page, "err", appErr.Error())
+			p.postCommandResponse(args, "Backfill of team `%s` stopped after %d members: failed to get the team members: `%s`", team.Name, summary.Members, appErr)
+			return
+		}
+
+		for _, member := range members {
+			summary.Members++
+			switch welcome, err := p.backfillMember(team, member, options, ticker); {
+			case err == errBackfillStopped:
+				summary.Members--
+				p.postCommandResponse(args, "Backfill of team `%s` stopped after %d members: %s.", team.Name, summary.Members, err)
+				return
+			case err != nil:
+				p.API.LogError("failed to backfill team member", "team_id", team.Id, "user_id", member.UserId, "err", err.Error())
+				summary.Failed++
+			case welcome:
+				summary.Welcomed++
+			default:
+				summary.Skipped++
+			}
+		}
+
+		if len(members) < backfillMembersPerPage {
+			break
+		}
+
+		p.postCommandResponse(args, "Backfill of team `%s` in progress: %d members processed, %d welcomed, %d skipped, %d failed.",
+			team.Name, summary.Members, summary.Welcomed, summary.Skipped, summary.Failed)
+	}
+
+	verb := "welcomed"
+	if options.DryRun {
+		verb = "would be welcomed"
+	}
+	p.postCommandResponse(args, "Backfill of team `%s` complete: %d members processed, %d %s, %d skipped, %d failed.",
+		team.Name, summary.Members, summary.Welcomed, verb, summary.Skipped, summary.Failed)
+}
+
+// backfillMember starts the welcome flow for a member of the team if they match the options. It
+// waits for the ticker before welcoming them, to limit the delivery rate, unless the plugin is
+// deactivated in the meantime.
+func (p *Plugin) backfillMember(team *model.Team, member *model.TeamMember, options *backfillOptions, ticker *time.Ticker) (bool, error) {
+	if member.DeleteAt > 0 || member.UserId == p.botUserID {
+		return false, nil
+	}
+
+	user, appErr := p.API.GetUser(member.UserId)
+	if appErr != nil {
+		return false, errors.Wrap(appErr, "failed to get user")
+	}
+	if user.IsBot || user.DeleteAt > 0 || (options.ExcludeGuests && user.IsGuest()) {
+		return false, nil
+	}
+
+	joinTime := getJoinTime(member, user)
+	if !options.JoinedAfter.IsZero() && joinTime.Before(options.JoinedAfter) {
+		return false, nil
+	}
+	if !options.JoinedBefore.IsZero() && !joinTime.Before(options.JoinedBefore) {
+		return false, nil
+	}
+
+	flowOptions := welcomeOptions{SkipDelivered: true}
+	if len(p.getUserWelcomeMessages(user, team, flowOptions)) == 0 {
+		return false, nil
+	}
+	if options.DryRun {
+		return true, nil
+	}
+
+	select {
+	case <-ticker.C:
+	case <-p.backfillStop:
+		return false, errBackfillStopped
+	}
+
+	data := p.constructMessageTemplate(user.Id, team.Id)
+	if data == nil {
+		return false, errors.New("failed to prepare the welcome messages")
+	}
+
+	return p.startWelcomeFlow(data, flowOptions) > 0, nil
+}
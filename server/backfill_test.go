@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseBackfillOptions(t *testing.T) {
+	for name, tc := range map[string]struct {
+		flags    map[string]string
+		expected *backfillOptions
+	}{
+		"defaults": {
+			expected: &backfillOptions{UsersPerMinute: defaultBackfillUsersPerMinute},
+		},
+		"all options": {
+			flags: map[string]string{
+				flagJoinedAfter:   "2024-01-02",
+				flagJoinedBefore:  "2024-03-04",
+				flagExcludeGuests: "",
+				flagDryRun:        "",
+				flagRate:          "120",
+			},
+			expected: &backfillOptions{
+				JoinedAfter:    time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+				JoinedBefore:   time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC),
+				ExcludeGuests:  true,
+				DryRun:         true,
+				UsersPerMinute: 120,
+			},
+		},
+		"highest rate":  {flags: map[string]string{flagRate: "600"}, expected: &backfillOptions{UsersPerMinute: maxBackfillUsersPerMinute}},
+		"rate too high": {flags: map[string]string{flagRate: "601"}},
+		"huge rate":     {flags: map[string]string{flagRate: "100000000000"}},
+		"zero rate":     {flags: map[string]string{flagRate: "0"}},
+		"invalid rate":  {flags: map[string]string{flagRate: "fast"}},
+		"invalid date":  {flags: map[string]string{flagJoinedAfter: "01/02/2024"}},
+		"unknown flag":  {flags: map[string]string{"--force": ""}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			options, err := parseBackfillOptions(tc.flags)
+			if tc.expected == nil {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", options)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if *options != *tc.expected {
+				t.Errorf("expected %+v, got %+v", tc.expected, options)
+			}
+		})
+	}
+}
@@ -0,0 +1,763 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	listKeysPerPage = 1000
+
+	// Delivery modes of the channel welcome messages
+	deliveryModeDMAndEphemeral = "dm_and_ephemeral"
+	deliveryModeDM             = "dm"
+	deliveryModeEphemeral      = "ephemeral"
+	deliveryModeChannel        = "channel"
+
+	// The ephemeral message sent as soon as a user joins a channel waits for their client to open
+	// the channel. See the discussion at the link below for more details:
+	// https://github.com/mattermost/mattermost-plugin-welcomebot/pull/31#issuecomment-611691023
+	ephemeralWelcomeDelay = 1 * time.Second
+)
+
+var channelDeliveryModes = []string{deliveryModeDMAndEphemeral, deliveryModeDM, deliveryModeEphemeral, deliveryModeChannel}
+
+// ChannelWelcomeRecord is the welcome message of a channel, as stored in the KV store. The fields
+// have the same meaning as the ones of ConfigMessage, the templates accessing the members of
+// ChannelMessageTemplate.
+type ChannelWelcomeRecord struct {
+	// The message to send
+	Message string
+
+	// The message to send as a slack attachment
+	AttachmentMessage string `json:",omitempty"`
+
+	// Actions that can be taken with this message
+	Actions []*ConfigMessageAction `json:",omitempty"`
+
+	// How the message is delivered: dm_and_ephemeral (default), dm, ephemeral or channel
+	DeliveryMode string `json:",omitempty"`
+
+	// Number of seconds to wait after the user joined the channel before sending the message
+	DelayInSeconds int `json:",omitempty"`
+
+	// Whether the message is sent again to users rejoining the channel: always (default), never or after_days
+	RejoinPolicy string `json:",omitempty"`
+
+	// Number of days since the last delivery after which rejoining users receive the message again, for the after_days policy
+	RejoinAfterDays int `json:",omitempty"`
+
+	// The user who last set the message. Actions only add users to the channels this user is
+	// allowed to add members to.
+	SetBy string `json:",omitempty"`
+}
+
+// ChannelWelcomeTranslation is the translation of the welcome message of a channel for a locale.
+// Untranslated parts are sent in the default language.
+type ChannelWelcomeTranslation struct {
+	Message           string `json:",omitempty"`
+	AttachmentMessage string `json:",omitempty"`
+}
+
+// UnmarshalJSON also reads the translations stored by older versions, which only translated the
+// message and stored it alone as a string.
+func (t *ChannelWelcomeTranslation) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		*t = ChannelWelcomeTranslation{}
+		return json.Unmarshal(data, &t.Message)
+	}
+
+	type translation ChannelWelcomeTranslation
+	return json.Unmarshal(data, (*translation)(t))
+}
+
+func (t *ChannelWelcomeTranslation) isEmpty() bool {
+	return t == nil || (t.Message == "" && t.AttachmentMessage == "")
+}
+
+// newChannelWelcomeTranslations gathers the translations of the message and of the attachment
+// message of a channel welcome message, by locale
+func newChannelWelcomeTranslations(messages, attachmentMessages map[string]string) map[string]*ChannelWelcomeTranslation {
+	translations := make(map[string]*ChannelWelcomeTranslation)
+	get := func(locale string) *ChannelWelcomeTranslation {
+		if translations[locale] == nil {
+			translations[locale] = &ChannelWelcomeTranslation{}
+		}
+		return translations[locale]
+	}
+	for locale, message := range normalizeTranslations(messages) {
+		get(locale).Message = message
+	}
+	for locale, message := range normalizeTranslations(attachmentMessages) {
+		get(locale).AttachmentMessage = message
+	}
+
+	return translations
+}
+
+// splitChannelWelcomeTranslations returns the translations of the message and of the attachment
+// message of a channel welcome message, by locale
+func splitChannelWelcomeTranslations(translations map[string]*ChannelWelcomeTranslation) (messages, attachmentMessages map[string]string) {
+	for locale, translation := range translations {
+		if translation == nil {
+			continue
+		}
+		if translation.Message != "" {
+			if messages == nil {
+				messages = make(map[string]string)
+			}
+			messages[locale] = translation.Message
+		}
+		if translation.AttachmentMessage != "" {
+			if attachmentMessages == nil {
+				attachmentMessages = make(map[string]string)
+			}
+			attachmentMessages[locale] = translation.AttachmentMessage
+		}
+	}
+
+	return messages, attachmentMessages
+}
+
+// getDeliveryMode returns the delivery mode of the message, which defaults to a direct message
+// along with an ephemeral message in the channel
+func (w *ChannelWelcomeRecord) getDeliveryMode() string {
+	if w.DeliveryMode == "" {
+		return deliveryModeDMAndEphemeral
+	}
+
+	return w.DeliveryMode
+}
+
+// getAction returns the button action with the given name, or nil if there is none
+func (w *ChannelWelcomeRecord) getAction(actionName string) *ConfigMessageAction {
+	for _, action := range w.Actions {
+		if action != nil && action.ActionType == actionTypeButton && action.ActionName == actionName {
+			return action
+		}
+	}
+
+	return nil
+}
+
+// localized returns a copy of the welcome message with its actions translated for the first of
+// the fallbacks which has a translation
+func (w ChannelWelcomeRecord) localized(fallbacks []string) *ChannelWelcomeRecord {
+	actions := make([]*ConfigMessageAction, 0, len(w.Actions))
+	for _, action := range w.Actions {
+		if action != nil {
+			actions = append(actions, action.localized(fallbacks))
+		}
+	}
+	w.Actions = actions
+
+	return &w
+}
+
+func getChannelWelcomeKey(channelID string) string {
+	return fmt.Sprintf("%s%s", welcomebotChannelWelcomeKey, channelID)
+}
+
+// getChannelWelcome returns the welcome message of the channel, or nil if none is set. Older
+// versions stored the message alone as raw text, which is read as a record with only a message.
+func (p *Plugin) getChannelWelcome(channelID string) (*ChannelWelcomeRecord, error) {
+	data, appErr := p.API.KVGet(getChannelWelcomeKey(channelID))
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	return p.decodeChannelWelcome(data), nil
+}
+
+func (p *Plugin) decodeChannelWelcome(data []byte) *ChannelWelcomeRecord {
+	if len(data) == 0 {
+		return nil
+	}
+
+	var welcome *ChannelWelcomeRecord
+	if data[0] == '{' && json.Unmarshal(data, &welcome) == nil && welcome != nil {
+		return welcome
+	}
+
+	return &ChannelWelcomeRecord{Message: p.escapeLegacyChannelWelcome(string(data))}
+}
+
+// escapeLegacyChannelWelcome escapes the {{ of a channel welcome message stored as text before
+// channel welcome messages were templates, when it does not parse as a template, so that it is
+// still sent as it was written.
+func (p *Plugin) escapeLegacyChannelWelcome(message string) string {
+	if _, err := p.parseTemplate("LegacyChannelResponse", []string{message}); err == nil {
+		return message
+	}
+
+	return strings.ReplaceAll(message, "{{", `{{"{{"}}`)
+}
+
+func (p *Plugin) setChannelWelcome(channelID string, welcome *ChannelWelcomeRecord) error {
+	data, err := json.Marshal(welcome)
+	if err != nil {
+		return err
+	}
+	if appErr := p.API.KVSet(getChannelWelcomeKey(channelID), data); appErr != nil {
+		return appErr
+	}
+
+	return nil
+}
+
+// deleteChannelWelcome deletes the welcome message of the channel, along with its translations.
+func (p *Plugin) deleteChannelWelcome(channelID string) error {
+	if appErr := p.API.KVDelete(getChannelWelcomeKey(channelID)); appErr != nil {
+		return appErr
+	}
+
+	return p.setChannelWelcomeTranslations(channelID, nil)
+}
+
+func getChannelWelcomeTranslationsKey(channelID string) string {
+	return fmt.Sprintf("%s%s", welcomebotChannelTranslationsKey, channelID)
+}
+
+// getChannelWelcomeTranslations returns the translations of the welcome message of the channel,
+// by locale.
+func (p *Plugin) getChannelWelcomeTranslations(channelID string) (map[string]*ChannelWelcomeTranslation, error) {
+	data, appErr := p.API.KVGet(getChannelWelcomeTranslationsKey(channelID))
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	translations := make(map[string]*ChannelWelcomeTranslation)
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &translations); err != nil {
+			return nil, errors.Wrap(err, "failed to decode the translations")
+		}
+	}
+
+	return translations, nil
+}
+
+// setChannelWelcomeTranslations replaces the translations of the welcome message of the channel.
+func (p *Plugin) setChannelWelcomeTranslations(channelID string, translations map[string]*ChannelWelcomeTranslation) error {
+	if len(translations) == 0 {
+		if appErr := p.API.KVDelete(getChannelWelcomeTranslationsKey(channelID)); appErr != nil {
+			return appErr
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(translations)
+	if err != nil {
+		return err
+	}
+	if appErr := p.API.KVSet(getChannelWelcomeTranslationsKey(channelID), data); appErr != nil {
+		return appErr
+	}
+
+	return nil
+}
+
+// updateChannelWelcomeTranslation updates the translation of the welcome message of the channel
+// for a locale. The translation is deleted if update leaves it empty.
+// Translations of other locales set at the same time are kept.
+func (p *Plugin) updateChannelWelcomeTranslation(channelID, locale string, update func(translation *ChannelWelcomeTranslation)) error {
+	locale = normalizeLocale(locale)
+
+	return p.client.KV.SetAtomicWithRetries(getChannelWelcomeTranslationsKey(channelID), func(oldValue []byte) (interface{}, error) {
+		translations := make(map[string]*ChannelWelcomeTranslation)
+		if len(oldValue) > 0 {
+			if err := json.Unmarshal(oldValue, &translations); err != nil {
+				return nil, errors.Wrap(err, "failed to decode the translations")
+			}
+		}
+
+		translation := &ChannelWelcomeTranslation{}
+		if translations[locale] != nil {
+			translation = translations[locale]
+		}
+		update(translation)
+		if translation.isEmpty() {
+			delete(translations, locale)
+		} else {
+			translations[locale] = translation
+		}
+		if len(translations) == 0 {
+			return nil, nil
+		}
+		return translations, nil
+	})
+}
+
+// getLocalizedChannelWelcome returns the welcome message of the channel in the language of the
+// user, or in the default language if it has not been translated. It returns nil if the channel
+// has no welcome message.
+func (p *Plugin) getLocalizedChannelWelcome(channelID string, user *model.User) (*ChannelWelcomeRecord, error) {
+	welcome, err := p.getChannelWelcome(channelID)
+	if err != nil || welcome == nil {
+		return nil, err
+	}
+
+	return p.localizeChannelWelcome(channelID, welcome, user)
+}
+
+// localizeChannelWelcome returns the welcome message of the channel in the language of the user,
+// or in the default language if it has not been translated.
+func (p *Plugin) localizeChannelWelcome(channelID string, welcome *ChannelWelcomeRecord, user *model.User) (*ChannelWelcomeRecord, error) {
+	translations, err := p.getChannelWelcomeTranslations(channelID)
+	if err != nil {
+		return nil, err
+	}
+
+	fallbacks := p.getLocaleFallbacks(user)
+	localized := welcome.localized(fallbacks)
+	if translation, ok := findTranslation(translations, fallbacks); ok && translation != nil {
+		if translation.Message != "" {
+			localized.Message = translation.Message
+		}
+		if translation.AttachmentMessage != "" {
+			localized.AttachmentMessage = translation.AttachmentMessage
+		}
+	}
+
+	return localized, nil
+}
+
+// checkChannelWelcomeSupported makes sure the channel can have a welcome message. Direct and group
+// messages can't, and private channels opt in by adding the bot as a member.
+func (p *Plugin) checkChannelWelcomeSupported(channel *model.Channel) error {
+	switch channel.Type {
+	case model.ChannelTypeDirect, model.ChannelTypeGroup:
+		return errors.New("welcome messages are not supported for direct and group messages")
+	case model.ChannelTypePrivate:
+		isMember, err := p.isBotChannelMember(channel.Id)
+		if err != nil {
+			return errors.Wrap(err, "failed to check the membership of the bot")
+		}
+		if !isMember {
+			return errors.Errorf("welcome messages of private channels require @%s to be a member of the channel, please add it first", botUsername)
+		}
+	}
+
+	return nil
+}
+
+// isBotChannelMember tells whether the bot is a member of the channel. Only a missing membership
+// means it is not, other failures are returned.
+func (p *Plugin) isBotChannelMember(channelID string) (bool, error) {
+	if _, appErr := p.API.GetChannelMember(channelID, p.botUserID); appErr != nil {
+		if appErr.StatusCode == http.StatusNotFound {
+			return false, nil
+		}
+		return false, appErr
+	}
+
+	return true, nil
+}
+
+// checkChannelWelcome makes sure the welcome message of a channel and its translations are valid
+// templates, and that its actions are well formed and add users to channels of the same team.
+func (p *Plugin) checkChannelWelcome(channel *model.Channel, welcome *ChannelWelcomeRecord, translations map[string]*ChannelWelcomeTranslation) error {
+	if welcome.DeliveryMode != "" && !slices.Contains(channelDeliveryModes, welcome.DeliveryMode) {
+		return errors.Errorf("unknown delivery mode `%s`, expected one of `%s`", welcome.DeliveryMode, strings.Join(channelDeliveryModes, "`, `"))
+	}
+	if welcome.DelayInSeconds < 0 {
+		return errors.New("the delay must not be negative")
+	}
+	if err := checkRejoinPolicy(welcome.RejoinPolicy, welcome.RejoinAfterDays); err != nil {
+		return err
+	}
+	if _, err := p.parseTemplate("ChannelResponse", []string{welcome.Message}); err != nil {
+		return errors.Wrap(err, "the message template is invalid")
+	}
+	if _, err := p.parseTemplate("ChannelAttachmentResponse", []string{welcome.AttachmentMessage}); err != nil {
+		return errors.Wrap(err, "the attachment message template is invalid")
+	}
+	for locale, translation := range translations {
+		if translation == nil {
+			continue
+		}
+		if _, err := p.parseTemplate("ChannelResponse", []string{translation.Message}); err != nil {
+			return errors.Wrapf(err, "the %s message template is invalid", locale)
+		}
+		if _, err := p.parseTemplate("ChannelAttachmentResponse", []string{translation.AttachmentMessage}); err != nil {
+			return errors.Wrapf(err, "the %s attachment message template is invalid", locale)
+		}
+	}
+
+	var team *model.Team
+	if channel != nil && channel.TeamId != "" {
+		var appErr *model.AppError
+		if team, appErr = p.API.GetTeam(channel.TeamId); appErr != nil {
+			return errors.Wrap(appErr, "failed to get the team of the channel")
+		}
+	}
+	if problems := p.checkActions("the message", welcome.Actions, team, make(map[string]bool)); len(problems) > 0 {
+		return errors.New(strings.Join(problems, ", "))
+	}
+
+	return nil
+}
+
+// newChannelMessageTemplate builds the data of the channel welcome message template for the user
+// joining the channel. actorID is the user who added them, if any. The users are sanitized, as the
+// message is written by the channel admins and may be posted publicly.
+func (p *Plugin) newChannelMessageTemplate(channel *model.Channel, user *model.User, actorID string) *ChannelMessageTemplate {
+	lazy := p.newLazyTemplateData()
+	lazy.sanitizeUsers(p.API.GetConfig())
+	user = lazy.sanitizeUser(user)
+
+	data := &ChannelMessageTemplate{
+		MessageTemplate: MessageTemplate{
+			User:            user,
+			UserDisplayName: user.GetDisplayName(model.ShowNicknameFullName),
+			lazy:            lazy,
+		},
+		Channel: channel,
+	}
+	data.setActor(actorID)
+
+	if channel.TeamId != "" {
+		team, appErr := p.API.GetTeam(channel.TeamId)
+		if appErr != nil {
+			p.API.LogError("failed to query team", "team_id", channel.TeamId, "err", appErr.Error())
+		}
+		data.Team = team
+	}
+
+	return data
+}
+
+// renderChannelWelcome renders the welcome message of a channel for the user joining it. Like for
+// the team welcome messages, the automatic actions are run first, so that the message can list the
+// channels joined.
+func (p *Plugin) renderChannelWelcome(welcome *ChannelWelcomeRecord, data *ChannelMessageTemplate) *model.Post {
+	actionButtons := make([]*model.PostAction, 0)
+	for _, configAction := range welcome.Actions {
+		if configAction == nil {
+			continue
+		}
+		switch configAction.ActionType {
+		case actionTypeAutomatic:
+			action := newChannelAction(data.Channel, data.User.Id, actionTypeAutomatic)
+			for _, channelName := range configAction.ChannelsAddedTo {
+				if channel := p.joinChannelWelcomeTarget(welcome, action, channelName); channel != nil {
+					data.addJoinedChannel(channel)
+				}
+			}
+		case actionTypeButton:
+			actionButtons = append(actionButtons, &model.PostAction{
+				Name: configAction.ActionDisplayName,
+				Integration: &model.PostActionIntegration{
+					Context: map[string]interface{}{
+						"action":     configAction.ActionName,
+						"team_id":    data.Channel.TeamId,
+						"channel_id": data.Channel.Id,
+						"user_id":    data.User.Id,
+					},
+					URL: fmt.Sprintf("%v/plugins/%v/channelaction", p.getSiteURL(), manifest.Id),
+				},
+			})
+		}
+	}
+
+	post := &model.Post{
+		Message: p.executeTemplate("ChannelResponse", []string{welcome.Message}, data),
+		UserId:  p.botUserID,
+	}
+
+	if welcome.AttachmentMessage != "" || len(actionButtons) > 0 {
+		attachment := &model.SlackAttachment{
+			Text: p.executeTemplate("ChannelAttachmentResponse", []string{welcome.AttachmentMessage}, data),
+		}
+		if len(actionButtons) > 0 {
+			attachment.Actions = actionButtons
+		}
+		post.Props = map[string]interface{}{
+			"attachments": []*model.SlackAttachment{attachment},
+		}
+	}
+
+	return post
+}
+
+// deliverChannelWelcome sends the welcome message of the channel to the user joining it, as set by
+// the delivery mode of the channel. waitForClient delays the ephemeral message until the client of
+// the user has opened the channel they just joined.
+func (p *Plugin) deliverChannelWelcome(channel *model.Channel, user *model.User, actorID string, welcome *ChannelWelcomeRecord, waitForClient bool) {
+	mode := welcome.getDeliveryMode()
+	post := p.renderChannelWelcome(welcome, p.newChannelMessageTemplate(channel, user, actorID))
+	if strings.TrimSpace(post.Message) == "" && len(post.Attachments()) == 0 {
+		return
+	}
+
+	var err error
+	switch mode {
+	case deliveryModeChannel:
+		err = p.postChannelGreeting(channel, user, post.Clone())
+	case deliveryModeDM, deliveryModeDMAndEphemeral:
+		err = p.postDirectChannelWelcome(user, post.Clone())
+	}
+
+	if err != nil {
+		p.API.LogError("failed to post channel welcome message", "channel_id", channel.Id, "user_id", user.Id, "delivery_mode", mode, "err", err.Error())
+		p.metrics.incWelcomesFailed(welcomeKindChannel)
+		p.updateStats(channel.TeamId, func(stats *OnboardingStats) {
+			stats.ChannelWelcomesFailed++
+		})
+	} else {
+		p.metrics.incWelcomesSent(welcomeKindChannel)
+		p.updateStats(channel.TeamId, func(stats *OnboardingStats) {
+			stats.ChannelWelcomesSent++
+		})
+		if err := p.completeChannelDelivery(user.Id, channel.Id); err != nil {
+			p.API.LogError("failed to record channel welcome message delivery", "channel_id", channel.Id, "user_id", user.Id, "err", err.Error())
+		}
+	}
+
+	if mode == deliveryModeEphemeral || mode == deliveryModeDMAndEphemeral {
+		if waitForClient {
+			time.Sleep(ephemeralWelcomeDelay)
+		}
+		ephemeralPost := post.Clone()
+		ephemeralPost.ChannelId = channel.Id
+		_ = p.API.SendEphemeralPost(user.Id, ephemeralPost)
+	}
+}
+
+// postDirectChannelWelcome sends the welcome message of a channel in the direct channel of the user
+// with the bot
+func (p *Plugin) postDirectChannelWelcome(user *model.User, post *model.Post) error {
+	dmChannel, appErr := p.API.GetDirectChannel(user.Id, p.botUserID)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to get the direct channel")
+	}
+
+	post.ChannelId = dmChannel.Id
+	if _, appErr := p.API.CreatePost(post); appErr != nil {
+		return errors.Wrap(appErr, "failed to create the post")
+	}
+
+	return nil
+}
+
+// postChannelGreeting posts the welcome message of a channel in the channel, for everyone to see.
+// The user is mentioned, unless the message already does.
+func (p *Plugin) postChannelGreeting(channel *model.Channel, user *model.User, post *model.Post) error {
+	if !mentionsUser(post.Message, user.Username) {
+		post.Message = strings.TrimSpace("@" + user.Username + " " + post.Message)
+	}
+
+	post.ChannelId = channel.Id
+	if _, appErr := p.API.CreatePost(post); appErr != nil {
+		return errors.Wrap(appErr, "failed to create the post")
+	}
+
+	return nil
+}
+
+// mentionsUser tells whether the message mentions the user. The mention must not be followed by
+// other characters of a username, so that @bobby is not a mention of bob, but may be followed by a
+// period ending a sentence.
+func mentionsUser(message, username string) bool {
+	mentionRegexp := regexp.MustCompile(`(?i)(^|[^a-z0-9._-])@` + regexp.QuoteMeta(username) + `($|[^a-z0-9._-]|\.($|[^a-z0-9_-]))`)
+	return mentionRegexp.MatchString(message)
+}
+
+// scheduleChannelWelcome queues the delivery of the welcome message of the channel, once its delay
+// has elapsed since the join
+func (p *Plugin) scheduleChannelWelcome(channel *model.Channel, userID, actorID string, joinedAt int64, delayInSeconds int) error {
+	job := &WelcomeJob{
+		UserID:    userID,
+		TeamID:    channel.TeamId,
+		ChannelID: channel.Id,
+		ActorID:   actorID,
+		JoinedAt:  joinedAt,
+		CreateAt:  model.GetMillis(),
+	}
+	runAt := time.UnixMilli(joinedAt).Add(time.Second * time.Duration(delayInSeconds))
+	if _, err := p.scheduler.ScheduleOnce(model.NewId(), runAt, job); err != nil {
+		return errors.Wrap(err, "failed to schedule the channel welcome message")
+	}
+
+	return nil
+}
+
+// handleChannelWelcomeJob delivers a delayed channel welcome message. Nothing is sent if the user
+// left the channel, rejoined it since, or if the channel no longer has a welcome message.
+func (p *Plugin) handleChannelWelcomeJob(key string, job *WelcomeJob) {
+	channel, appErr := p.API.GetChannel(job.ChannelID)
+	if appErr != nil {
+		p.API.LogWarn("dropping scheduled channel welcome message of a channel which has not been found", "job_key", key, "channel_id", job.ChannelID)
+		return
+	}
+	if channel.DeleteAt > 0 || p.checkChannelWelcomeSupported(channel) != nil {
+		return
+	}
+	if _, appErr := p.API.GetChannelMember(channel.Id, job.UserID); appErr != nil {
+		// The user left the channel in the meantime
+		return
+	}
+
+	record, err := p.getChannelDeliveryRecord(job.UserID, channel.Id)
+	if err != nil {
+		p.API.LogError("failed to get channel delivery record", "job_key", key, "channel_id", channel.Id, "err", err.Error())
+		return
+	}
+	if record.JoinedAt > job.JoinedAt {
+		// The delivery was superseded by a later join, with its own job
+		return
+	}
+
+	user, appErr := p.API.GetUser(job.UserID)
+	if appErr != nil {
+		p.API.LogError("failed to query user", "job_key", key, "user_id", job.UserID, "err", appErr.Error())
+		return
+	}
+
+	welcome, err := p.getLocalizedChannelWelcome(channel.Id, user)
+	if err != nil {
+		p.API.LogError("failed to get channel welcome message", "job_key", key, "channel_id", channel.Id, "err", err.Error())
+		return
+	}
+	if welcome == nil {
+		return
+	}
+
+	p.deliverChannelWelcome(channel, user, job.ActorID, welcome, false)
+
+	dueAt := time.UnixMilli(job.JoinedAt).Add(time.Second * time.Duration(welcome.DelayInSeconds))
+	p.metrics.observeDeliveryLatency(max(time.Since(dueAt), 0))
+}
+
+// joinChannelWelcomeTarget adds the user to a channel of an action of a channel welcome message.
+// The actions are set by channel admins, so the user who set the message must still be allowed to
+// add members to the channel.
+func (p *Plugin) joinChannelWelcomeTarget(welcome *ChannelWelcomeRecord, action *Action, channelName string) *model.Channel {
+	target, appErr := p.API.GetChannelByName(action.Context.TeamID, channelName, false)
+	if appErr == nil && !p.canManageChannelMembers(welcome.SetBy, target) {
+		p.API.LogWarn("skipping a channel of a channel welcome action its author can't add members to", "channel_id", action.Context.ChannelID, "target_channel_id", target.Id, "set_by", welcome.SetBy)
+		return nil
+	}
+
+	return p.joinChannel(action, channelName)
+}
+
+// canManageChannelMembers tells whether the user is allowed to add members to the channel
+func (p *Plugin) canManageChannelMembers(userID string, channel *model.Channel) bool {
+	if userID == "" {
+		return false
+	}
+
+	permission := model.PermissionManagePublicChannelMembers
+	if channel.Type == model.ChannelTypePrivate {
+		permission = model.PermissionManagePrivateChannelMembers
+	}
+
+	return p.API.HasPermissionToChannel(userID, channel.Id, permission)
+}
+
+// checkChannelWelcomeActionsAllowed makes sure the user setting the welcome message of a channel is
+// allowed to add members to all the channels of its actions
+func (p *Plugin) checkChannelWelcomeActionsAllowed(userID string, channel *model.Channel, welcome *ChannelWelcomeRecord) error {
+	for _, action := range welcome.Actions {
+		if action == nil {
+			continue
+		}
+		for _, channelName := range action.ChannelsAddedTo {
+			target, appErr := p.API.GetChannelByName(channel.TeamId, channelName, false)
+			if appErr != nil {
+				return errors.Errorf("channel `%s` of action `%s` has not been found", channelName, action.ActionName)
+			}
+			if !p.canManageChannelMembers(userID, target) {
+				return errors.Errorf("you are not allowed to add members to channel `%s` of action `%s`", channelName, action.ActionName)
+			}
+		}
+	}
+
+	return nil
+}
+
+// newChannelAction returns the action of a user on the welcome message of a channel, as handled
+// by joinChannel
+func newChannelAction(channel *model.Channel, userID, actionName string) *Action {
+	return &Action{
+		UserID: userID,
+		Context: &ActionContext{
+			TeamID:    channel.TeamId,
+			ChannelID: channel.Id,
+			UserID:    userID,
+			Action:    actionName,
+		},
+	}
+}
+
+// processChannelAction runs the action of a button of the welcome message of a channel, and
+// returns the message to show to the user
+func (p *Plugin) processChannelAction(data *ChannelMessageTemplate, welcome *ChannelWelcomeRecord, configAction *ConfigMessageAction) string {
+	p.recordHistoryEvent(data.User.Id, &HistoryEvent{
+		Type:       historyEventActionTaken,
+		TeamID:     data.Channel.TeamId,
+		ActionName: configAction.ActionName,
+	})
+	p.metrics.incActionClicks(configAction.ActionName)
+	p.recordActionClick(data.User.Id, data.Channel.TeamId, "", configAction.ActionName)
+
+	action := newChannelAction(data.Channel, data.User.Id, configAction.ActionName)
+	for _, channelName := range configAction.ChannelsAddedTo {
+		if channel := p.joinChannelWelcomeTarget(welcome, action, channelName); channel != nil {
+			data.addJoinedChannel(channel)
+		}
+	}
+
+	return p.executeTemplate("ChannelResponse", configAction.ActionSuccessfulMessage, data)
+}
+
+// listChannelWelcomes returns the welcome messages of all the channels, by channel ID.
+func (p *Plugin) listChannelWelcomes() (map[string]*ChannelWelcomeRecord, error) {
+	keys, err := p.listKeysWithPrefix(welcomebotChannelWelcomeKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list channel welcome messages")
+	}
+
+	welcomes := make(map[string]*ChannelWelcomeRecord, len(keys))
+	for _, key := range keys {
+		channelID := strings.TrimPrefix(key, welcomebotChannelWelcomeKey)
+		welcome, err := p.getChannelWelcome(channelID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get the welcome message of channel %s", channelID)
+		}
+		if welcome != nil {
+			welcomes[channelID] = welcome
+		}
+	}
+
+	return welcomes, nil
+}
+
+// listKeysWithPrefix returns all the keys of the KV store starting with the given prefix.
+func (p *Plugin) listKeysWithPrefix(prefix string) ([]string, error) {
+	var keys []string
+	for page := 0; ; page++ {
+		pageKeys, appErr := p.API.KVList(page, listKeysPerPage)
+		if appErr != nil {
+			return nil, appErr
+		}
+
+		for _, key := range pageKeys {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+
+		if len(pageKeys) < listKeysPerPage {
+			return keys, nil
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeChannelWelcome(t *testing.T) {
+	p := &Plugin{}
+	for name, tc := range map[string]struct {
+		data     string
+		expected *ChannelWelcomeRecord
+	}{
+		"not set":               {data: "", expected: nil},
+		"legacy text":           {data: "Welcome to the channel!", expected: &ChannelWelcomeRecord{Message: "Welcome to the channel!"}},
+		"legacy template":       {data: "{{.UserDisplayName}}, welcome!", expected: &ChannelWelcomeRecord{Message: "{{.UserDisplayName}}, welcome!"}},
+		"legacy braces text":    {data: "{not json}", expected: &ChannelWelcomeRecord{Message: "{not json}"}},
+		"legacy literal braces": {data: "Use {{name}} in the title", expected: &ChannelWelcomeRecord{Message: `Use {{"{{"}}name}} in the title`}},
+		"record": {
+			data: `{"Message":"Welcome!","AttachmentMessage":"Pick a channel","Actions":[{"ActionType":"button","ActionName":"alerts","ActionDisplayName":"Alerts","ChannelsAddedTo":["alerts"]}]}`,
+			expected: &ChannelWelcomeRecord{
+				Message:           "Welcome!",
+				AttachmentMessage: "Pick a channel",
+				Actions: []*ConfigMessageAction{{
+					ActionType:        actionTypeButton,
+					ActionName:        "alerts",
+					ActionDisplayName: "Alerts",
+					ChannelsAddedTo:   []string{"alerts"},
+				}},
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if welcome := p.decodeChannelWelcome([]byte(tc.data)); !reflect.DeepEqual(welcome, tc.expected) {
+				t.Errorf("expected %+v, got %+v", tc.expected, welcome)
+			}
+		})
+	}
+}
+
+func TestChannelWelcomeRecordGetAction(t *testing.T) {
+	welcome := &ChannelWelcomeRecord{
+		Message: "Welcome!",
+		Actions: []*ConfigMessageAction{
+			nil,
+			{ActionType: actionTypeAutomatic, ActionName: "general", ChannelsAddedTo: []string{"general"}},
+			{ActionType: actionTypeButton, ActionName: "alerts", ActionDisplayName: "Alerts", ChannelsAddedTo: []string{"alerts"}},
+		},
+	}
+
+	for name, tc := range map[string]struct {
+		actionName string
+		expected   *ConfigMessageAction
+	}{
+		"button":    {actionName: "alerts", expected: welcome.Actions[2]},
+		"automatic": {actionName: "general", expected: nil},
+		"unknown":   {actionName: "random", expected: nil},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if action := welcome.getAction(tc.actionName); action != tc.expected {
+				t.Errorf("expected %+v, got %+v", tc.expected, action)
+			}
+		})
+	}
+}
+
+func TestCheckChannelWelcome(t *testing.T) {
+	p := &Plugin{}
+	for name, tc := range map[string]struct {
+		welcome *ChannelWelcomeRecord
+		valid   bool
+	}{
+		"message only":          {welcome: &ChannelWelcomeRecord{Message: "Welcome {{.UserDisplayName}}!"}, valid: true},
+		"delivery mode":         {welcome: &ChannelWelcomeRecord{Message: "Welcome!", DeliveryMode: deliveryModeChannel}, valid: true},
+		"delay":                 {welcome: &ChannelWelcomeRecord{Message: "Welcome!", DelayInSeconds: 60}, valid: true},
+		"unknown delivery mode": {welcome: &ChannelWelcomeRecord{Message: "Welcome!", DeliveryMode: "email"}},
+		"negative delay":        {welcome: &ChannelWelcomeRecord{Message: "Welcome!", DelayInSeconds: -1}},
+		"rejoin once":           {welcome: &ChannelWelcomeRecord{Message: "Welcome!", RejoinPolicy: rejoinPolicyNever}, valid: true},
+		"rejoin after days":     {welcome: &ChannelWelcomeRecord{Message: "Welcome!", RejoinPolicy: rejoinPolicyAfterDays, RejoinAfterDays: 30}, valid: true},
+		"rejoin without days":   {welcome: &ChannelWelcomeRecord{Message: "Welcome!", RejoinPolicy: rejoinPolicyAfterDays}},
+		"unknown rejoin policy": {welcome: &ChannelWelcomeRecord{Message: "Welcome!", RejoinPolicy: "sometimes"}},
+		"invalid template":      {welcome: &ChannelWelcomeRecord{Message: "Welcome {{.UserDisplayName"}},
+		"invalid attachment":    {welcome: &ChannelWelcomeRecord{Message: "Welcome!", AttachmentMessage: "{{end}}"}},
+		"button without name":   {welcome: &ChannelWelcomeRecord{Message: "Welcome!", Actions: []*ConfigMessageAction{{ActionType: actionTypeButton, ActionDisplayName: "Alerts"}}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := p.checkChannelWelcome(nil, tc.welcome, nil); (err == nil) != tc.valid {
+				t.Errorf("expected valid %v, got error %v", tc.valid, err)
+			}
+		})
+	}
+}
+
+func TestMentionsUser(t *testing.T) {
+	for name, tc := range map[string]struct {
+		message  string
+		expected bool
+	}{
+		"start":              {message: "@bob welcome!", expected: true},
+		"middle":             {message: "Welcome @bob, enjoy", expected: true},
+		"end of sentence":    {message: "Welcome @bob.", expected: true},
+		"case":               {message: "Welcome @Bob!", expected: true},
+		"longer username":    {message: "Welcome @bobby!"},
+		"dotted username":    {message: "Welcome @bob.smith!"},
+		"dashed username":    {message: "Welcome @bob-smith!"},
+		"email":              {message: "Write to admin@bob"},
+		"not mentioned":      {message: "Welcome!"},
+		"other user mention": {message: "Ask @alice"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if mentioned := mentionsUser(tc.message, "bob"); mentioned != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, mentioned)
+			}
+		})
+	}
+}
+
+func TestDecodeChannelWelcomeTranslations(t *testing.T) {
+	for name, tc := range map[string]struct {
+		data     string
+		expected map[string]*ChannelWelcomeTranslation
+	}{
+		"legacy message only": {
+			data:     `{"fr": "Bienvenue !"}`,
+			expected: map[string]*ChannelWelcomeTranslation{"fr": {Message: "Bienvenue !"}},
+		},
+		"message and attachment": {
+			data:     `{"fr": {"Message": "Bienvenue !", "AttachmentMessage": "Lisez ceci"}, "de": {"AttachmentMessage": "Lies das"}}`,
+			expected: map[string]*ChannelWelcomeTranslation{"fr": {Message: "Bienvenue !", AttachmentMessage: "Lisez ceci"}, "de": {AttachmentMessage: "Lies das"}},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var translations map[string]*ChannelWelcomeTranslation
+			if err := json.Unmarshal([]byte(tc.data), &translations); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(translations, tc.expected) {
+				t.Errorf("expected %+v, got %+v", tc.expected, translations)
+			}
+		})
+	}
+}
+
+func TestChannelWelcomeTranslationsRoundTrip(t *testing.T) {
+	messages := map[string]string{"fr": "Bienvenue !", "pt_br": "Bem-vindo!"}
+	attachmentMessages := map[string]string{"fr": "Lisez ceci", "de": "Lies das", "ja": " "}
+
+	translations := newChannelWelcomeTranslations(messages, attachmentMessages)
+	expected := map[string]*ChannelWelcomeTranslation{
+		"fr":    {Message: "Bienvenue !", AttachmentMessage: "Lisez ceci"},
+		"pt-br": {Message: "Bem-vindo!"},
+		"de":    {AttachmentMessage: "Lies das"},
+	}
+	if !reflect.DeepEqual(translations, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, translations)
+	}
+
+	gotMessages, gotAttachmentMessages := splitChannelWelcomeTranslations(translations)
+	if expected := map[string]string{"fr": "Bienvenue !", "pt-br": "Bem-vindo!"}; !reflect.DeepEqual(gotMessages, expected) {
+		t.Errorf("expected messages %v, got %v", expected, gotMessages)
+	}
+	if expected := map[string]string{"fr": "Lisez ceci", "de": "Lies das"}; !reflect.DeepEqual(gotAttachmentMessages, expected) {
+		t.Errorf("expected attachment messages %v, got %v", expected, gotAttachmentMessages)
+	}
+}
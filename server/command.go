@@ -1,7 +1,10 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/mattermost/mattermost/server/public/model"
@@ -10,10 +13,30 @@ import (
 
 const commandHelp = `* |/welcomebot preview [team-name] | - preview the welcome message for the given team name. The current user's username will be used to render the template.
 * |/welcomebot list| - list the teams for which welcome messages were defined.
-The following commands will only be allowed to be run by system admins and users with permission to manage channel roles. |set_channel_welcome|, |get_channel_welcome| and |delete_channel_welcome|.
-* |/welcomebot set_channel_welcome [welcome-message]| - set the welcome message for the given channel. Direct channels are not supported.
-* |/welcomebot get_channel_welcome| - print the welcome message set for the given channel (if any)
-* |/welcomebot delete_channel_welcome| - delete the welcome message for the given channel (if any)
+The following commands will only be allowed to be run by system admins and users with permission to manage channel roles. |set_channel_welcome|, |get_channel_welcome|, |delete_channel_welcome| and |reset_channel_welcome|.
+* |/welcomebot set_channel_welcome [--locale=language] [welcome-message]| - set the welcome message for the given channel. Direct and group messages are not supported. In private channels, add @welcomebot to the channel first. With |--locale|, set the translation of the message sent to users of that language, e.g. |fr| or |pt-BR|, or of its attachment with |--locale=language --attachment|.
+* |/welcomebot set_channel_welcome --attachment [attachment-message]| - set the attachment of the welcome message of the given channel
+* |/welcomebot set_channel_welcome --actions [actions]| - set the actions of the welcome message of the given channel, as a JSON list of actions like in the team welcome messages
+* |/welcomebot set_channel_welcome --delivery=[dm_and_ephemeral|dm|ephemeral|channel]| - set how the welcome message of the given channel is delivered: as a direct message along with an ephemeral message in the channel (default), only one of them, or as a post in the channel mentioning the user
+* |/welcomebot set_channel_welcome --delay=[seconds]| - set the number of seconds to wait after users join the given channel before sending its welcome message
+* |/welcomebot set_channel_welcome --rejoin=[always|never|days]| - set whether users rejoining the given channel receive its welcome message again: always (default), never, or once the given number of days has passed since they last received it
+* |/welcomebot get_channel_welcome| - print the welcome message set for the given channel (if any), along with its attachment, actions, delivery settings and translations
+* |/welcomebot delete_channel_welcome [--locale=language|--attachment|--actions]| - delete the welcome message for the given channel (if any), or only its translation for the given language, its attachment or its actions
+* |/welcomebot reset_channel_welcome [@username]| - forget that the given user received the welcome message of the given channel, so that they receive it on their next join
+The following commands will only be allowed to be run by system admins and team admins, for the teams they administer.
+* |/welcomebot team_welcome create| - open a dialog to create a team welcome message
+* |/welcomebot team_welcome edit [id]| - open a dialog to edit the team welcome message with the given ID
+* |/welcomebot team_welcome delete [id]| - delete the team welcome message with the given ID
+* |/welcomebot team_welcome list| - list the team welcome messages you can manage, along with where they are defined
+* |/welcomebot variants [id]| - compare the click-through of the variants of the team welcome message with the given ID
+* |/welcomebot stats [team-name] [--since=YYYY-MM-DD]| - show the welcomes sent and failed and the actions clicked in the given team, or in all the teams for system admins, over the last 30 days or since the given day
+The following commands will only be allowed to be run by system admins.
+* |/welcomebot history [@username]| - show the welcome messages sent to the given user, the actions they took and the channels they joined
+* |/welcomebot resend [team-name] [@username...] [--skip-automatic]| - send the welcome messages of the given team again to the given users. |--skip-automatic| skips the automatic actions of the messages.
+* |/welcomebot export [json|yaml]| - send a file with all the team and channel welcome messages
+* |/welcomebot import [--mode=merge|replace] [--dry-run]| - import the welcome messages of the last file you uploaded in the current channel
+* |/welcomebot doctor [--fix]| - check the environment for problems preventing the delivery of the welcome messages. |--fix| repairs the problems the plugin can fix itself.
+* |/welcomebot backfill [team-name] [--joined-after=YYYY-MM-DD] [--joined-before=YYYY-MM-DD] [--exclude-guests] [--rate=users-per-minute] [--dry-run]| - send the welcome messages of the given team to its existing members who never received them
 `
 
 const (
@@ -22,7 +45,30 @@ const (
 	commandTriggerSetChannelWelcome    = "set_channel_welcome"
 	commandTriggerGetChannelWelcome    = "get_channel_welcome"
 	commandTriggerDeleteChannelWelcome = "delete_channel_welcome"
+	commandTriggerResetChannelWelcome  = "reset_channel_welcome"
+	commandTriggerHistory              = "history"
+	commandTriggerResend               = "resend"
+	commandTriggerBackfill             = "backfill"
+	commandTriggerTeamWelcome          = "team_welcome"
+	commandTriggerExport               = "export"
+	commandTriggerImport               = "import"
+	commandTriggerDoctor               = "doctor"
+	commandTriggerVariants             = "variants"
+	commandTriggerStats                = "stats"
 	commandTriggerHelp                 = "help"
+
+	flagSkipAutomatic = "--skip-automatic"
+	flagLocale        = "--locale"
+	flagAttachment    = "--attachment"
+	flagActions       = "--actions"
+	flagDelivery      = "--delivery"
+	flagDelay         = "--delay"
+	flagRejoin        = "--rejoin"
+
+	teamWelcomeTriggerCreate = "create"
+	teamWelcomeTriggerEdit   = "edit"
+	teamWelcomeTriggerDelete = "delete"
+	teamWelcomeTriggerList   = "list"
 )
 
 func getCommand() *model.Command {
@@ -31,7 +77,7 @@ func getCommand() *model.Command {
 		DisplayName:      "welcomebot",
 		Description:      "Welcome Bot helps add new team members to channels.",
 		AutoComplete:     true,
-		AutoCompleteDesc: "Available commands: preview, help, list, set_channel_welcome, get_channel_welcome, delete_channel_welcome",
+		AutoCompleteDesc: "Available commands: preview, help, list, set_channel_welcome, get_channel_welcome, delete_channel_welcome, reset_channel_welcome, history, resend, backfill, team_welcome, variants, stats, export, import, doctor",
 		AutoCompleteHint: "[command]",
 		AutocompleteData: getAutocompleteData(),
 	}
@@ -57,6 +103,24 @@ func (p *Plugin) hasSysadminRole(userID string) (bool, error) {
 	return true, nil
 }
 
+// parseFlags splits the parameters of a command into positional arguments and flags of the form
+// --name or --name=value.
+func parseFlags(parameters []string) ([]string, map[string]string) {
+	var positional []string
+	flags := make(map[string]string)
+	for _, parameter := range parameters {
+		if !strings.HasPrefix(parameter, "--") {
+			positional = append(positional, parameter)
+			continue
+		}
+
+		name, value, _ := strings.Cut(parameter, "=")
+		flags[name] = value
+	}
+
+	return positional, flags
+}
+
 func (p *Plugin) validateCommand(action string, parameters []string) string {
 	switch action {
 	case commandTriggerPreview:
@@ -76,8 +140,56 @@ func (p *Plugin) validateCommand(action string, parameters []string) string {
 			return "`get_channel_welcome` command does not accept any extra parameters"
 		}
 	case commandTriggerDeleteChannelWelcome:
-		if len(parameters) > 0 {
-			return "`delete_channel_welcome` command does not accept any extra parameters"
+		if len(parameters) > 1 || (len(parameters) == 1 && !strings.HasPrefix(parameters[0], flagLocale+"=") && parameters[0] != flagAttachment && parameters[0] != flagActions) {
+			return fmt.Sprintf("`delete_channel_welcome` command only accepts one of the `%s`, `%s` and `%s` options", flagLocale, flagAttachment, flagActions)
+		}
+	case commandTriggerResetChannelWelcome:
+		if len(parameters) != 1 {
+			return "Please specify the user whose welcome state should be reset."
+		}
+	case commandTriggerHistory:
+		if len(parameters) != 1 {
+			return "Please specify the user whose history should be shown."
+		}
+	case commandTriggerResend:
+		if len(parameters) < 2 {
+			return "Please specify a team and at least one user to resend the welcome messages to."
+		}
+	case commandTriggerBackfill:
+		if len(parameters) == 0 {
+			return "Please specify a team to backfill."
+		}
+	case commandTriggerExport:
+		if len(parameters) > 1 || (len(parameters) == 1 && parameters[0] != exportFormatJSON && parameters[0] != exportFormatYAML) {
+			return "Please specify `json` or `yaml` as the format of the export."
+		}
+	case commandTriggerDoctor:
+		if len(parameters) > 1 || (len(parameters) == 1 && parameters[0] != flagFix) {
+			return fmt.Sprintf("`doctor` command only accepts the `%s` option", flagFix)
+		}
+	case commandTriggerVariants:
+		if len(parameters) != 1 {
+			return "Please specify the ID of the team welcome message whose variants should be compared."
+		}
+	case commandTriggerStats:
+		if positional, _ := parseFlags(parameters); len(positional) > 1 {
+			return "Please specify at most one team."
+		}
+	case commandTriggerTeamWelcome:
+		if len(parameters) == 0 {
+			return "Please specify one of `create`, `edit`, `delete` or `list`."
+		}
+		switch parameters[0] {
+		case teamWelcomeTriggerCreate, teamWelcomeTriggerList:
+			if len(parameters) != 1 {
+				return fmt.Sprintf("`team_welcome %s` command does not accept any extra parameters", parameters[0])
+			}
+		case teamWelcomeTriggerEdit, teamWelcomeTriggerDelete:
+			if len(parameters) != 2 {
+				return fmt.Sprintf("Please specify the ID of the team welcome message to %s.", parameters[0])
+			}
+		default:
+			return fmt.Sprintf("Unknown action `%s`, please specify one of `create`, `edit`, `delete` or `list`.", parameters[0])
 		}
 	}
 
@@ -110,18 +222,28 @@ func (p *Plugin) executeCommandList(args *model.CommandArgs) {
 		return
 	}
 
-	// Deduplicate entries
-	teams := make(map[string]struct{})
+	// Deduplicate entries, keeping track of where they are defined
+	teams := make(map[string]map[string]struct{})
 	for _, message := range wecomeMessages {
-		teams[message.TeamName] = struct{}{}
+		if teams[message.TeamName] == nil {
+			teams[message.TeamName] = make(map[string]struct{})
+		}
+		teams[message.TeamName][message.Source] = struct{}{}
 	}
 
 	var str strings.Builder
 	str.WriteString("Teams for which welcome messages are defined:")
-	for team := range teams {
-		str.WriteString(fmt.Sprintf("\n * %s", team))
+	for team, sources := range teams {
+		var names []string
+		if _, ok := sources[messageSourceConfig]; ok {
+			names = append(names, "config.json")
+		}
+		if _, ok := sources[messageSourceStore]; ok {
+			names = append(names, "managed in Mattermost")
+		}
+		str.WriteString(fmt.Sprintf("\n * %s (%s)", team, strings.Join(names, ", ")))
 	}
-	p.postCommandResponse(args, str.String())
+	p.postCommandResponse(args, "%s", str.String())
 }
 
 func (p *Plugin) executeCommandSetWelcome(args *model.CommandArgs) {
@@ -131,8 +253,8 @@ func (p *Plugin) executeCommandSetWelcome(args *model.CommandArgs) {
 		return
 	}
 
-	if channelInfo.Type == model.ChannelTypePrivate {
-		p.postCommandResponse(args, "welcome messages are not supported for direct channels")
+	if err := p.checkChannelWelcomeSupported(channelInfo); err != nil {
+		p.postCommandResponse(args, "%s", err)
 		return
 	}
 
@@ -141,53 +263,597 @@ func (p *Plugin) executeCommandSetWelcome(args *model.CommandArgs) {
 	message := strings.SplitN(args.Command, "set_channel_welcome", 2)[1]
 	message = strings.TrimSpace(message)
 
-	key := fmt.Sprintf("%s%s", welcomebotChannelWelcomeKey, args.ChannelId)
-	if appErr := p.API.KVSet(key, []byte(message)); appErr != nil {
-		p.postCommandResponse(args, "error occurred while storing the welcome message for the chanel: `%s`", appErr)
+	if strings.HasPrefix(message, flagLocale+"=") {
+		var flag string
+		flag, message, _ = strings.Cut(message, " ")
+		message = strings.TrimSpace(message)
+		locale := strings.TrimPrefix(flag, flagLocale+"=")
+		attachment := false
+		if next, value, _ := strings.Cut(message, " "); next == flagAttachment {
+			attachment, message = true, strings.TrimSpace(value)
+		}
+		if locale == "" || message == "" {
+			p.postCommandResponse(args, "`set_channel_welcome %s=language` requires a language and the translated message", flagLocale)
+			return
+		}
+
+		translation := &ChannelWelcomeTranslation{Message: message}
+		if attachment {
+			translation = &ChannelWelcomeTranslation{AttachmentMessage: message}
+		}
+		if err := p.checkChannelWelcome(channelInfo, &ChannelWelcomeRecord{}, map[string]*ChannelWelcomeTranslation{locale: translation}); err != nil {
+			p.postCommandResponse(args, "the translation of the welcome message is invalid: `%s`", err)
+			return
+		}
+
+		err := p.updateChannelWelcomeTranslation(args.ChannelId, locale, func(stored *ChannelWelcomeTranslation) {
+			if attachment {
+				stored.AttachmentMessage = message
+			} else {
+				stored.Message = message
+			}
+		})
+		if err != nil {
+			p.postCommandResponse(args, "error occurred while storing the translation of the welcome message for the chanel: `%s`", err)
+			return
+		}
+
+		if attachment {
+			p.postCommandResponse(args, "stored the `%s` translation of the attachment of the welcome message:\n%s", locale, message)
+		} else {
+			p.postCommandResponse(args, "stored the `%s` translation of the welcome message:\n%s", locale, message)
+		}
+		return
+	}
+
+	welcome, err := p.getChannelWelcome(args.ChannelId)
+	if err != nil {
+		p.postCommandResponse(args, "error occurred while retrieving the welcome message for the chanel: `%s`", err)
+		return
+	}
+
+	// The attachment, the actions and the delivery settings complete the message, which is kept
+	// when they are set
+	flag, value, _ := strings.Cut(message, " ")
+	value = strings.TrimSpace(value)
+	flag, setting, _ := strings.Cut(flag, "=")
+	switch flag {
+	case flagAttachment, flagActions, flagDelivery, flagDelay, flagRejoin:
+		if welcome == nil {
+			p.postCommandResponse(args, "welcome message has not been set yet, set it before its attachment, actions and delivery settings")
+			return
+		}
+	}
+	if (flag == flagAttachment || flag == flagActions) && value == "" {
+		p.postCommandResponse(args, "`set_channel_welcome %s` requires a value", flag)
+		return
+	}
+	if (flag == flagDelivery || flag == flagDelay || flag == flagRejoin) && setting == "" {
+		p.postCommandResponse(args, "`set_channel_welcome %s=[setting]` requires a setting, given after the `=`", flag)
 		return
 	}
 
-	p.postCommandResponse(args, "stored the welcome message:\n%s", message)
+	var updated ChannelWelcomeRecord
+	if welcome != nil {
+		updated = *welcome
+	}
+	switch flag {
+	case flagDelivery:
+		updated.DeliveryMode = setting
+	case flagDelay:
+		delay, err := strconv.Atoi(setting)
+		if err != nil {
+			p.postCommandResponse(args, "`set_channel_welcome %s=seconds` requires a number of seconds", flagDelay)
+			return
+		}
+		updated.DelayInSeconds = delay
+	case flagRejoin:
+		updated.RejoinPolicy, updated.RejoinAfterDays = setting, 0
+		if days, err := strconv.Atoi(setting); err == nil {
+			updated.RejoinPolicy, updated.RejoinAfterDays = rejoinPolicyAfterDays, days
+		}
+	case flagAttachment:
+		updated.AttachmentMessage = value
+	case flagActions:
+		var actions []*ConfigMessageAction
+		if err := json.Unmarshal([]byte(value), &actions); err != nil {
+			p.postCommandResponse(args, "the actions must be a JSON list of actions, like the ones of the team welcome messages: `%s`", err)
+			return
+		}
+		updated.Actions = actions
+	default:
+		updated.Message = message
+	}
+
+	if err := p.checkChannelWelcome(channelInfo, &updated, nil); err != nil {
+		p.postCommandResponse(args, "the welcome message is invalid: `%s`", err)
+		return
+	}
+	if err := p.checkChannelWelcomeActionsAllowed(args.UserId, channelInfo, &updated); err != nil {
+		p.postCommandResponse(args, "%s", err)
+		return
+	}
+	updated.SetBy = args.UserId
+
+	if err := p.setChannelWelcome(args.ChannelId, &updated); err != nil {
+		p.postCommandResponse(args, "error occurred while storing the welcome message for the chanel: `%s`", err)
+		return
+	}
+
+	switch flag {
+	case flagAttachment:
+		p.postCommandResponse(args, "stored the attachment of the welcome message:\n%s", updated.AttachmentMessage)
+	case flagActions:
+		p.postCommandResponse(args, "stored %d action(s) of the welcome message", len(updated.Actions))
+	case flagDelivery:
+		p.postCommandResponse(args, "the welcome message is now delivered with the `%s` mode", updated.getDeliveryMode())
+	case flagDelay:
+		if updated.DelayInSeconds == 0 {
+			p.postCommandResponse(args, "the welcome message is now sent as soon as users join the channel")
+		} else {
+			p.postCommandResponse(args, "the welcome message is now sent %d seconds after users join the channel", updated.DelayInSeconds)
+		}
+	case flagRejoin:
+		p.postCommandResponse(args, "%s", formatChannelRejoinPolicy(&updated))
+	default:
+		p.postCommandResponse(args, "stored the welcome message:\n%s", message)
+	}
+}
+
+// formatChannelRejoinPolicy describes whether users rejoining the channel receive its welcome
+// message again
+func formatChannelRejoinPolicy(welcome *ChannelWelcomeRecord) string {
+	switch welcome.RejoinPolicy {
+	case rejoinPolicyNever:
+		return "users receive the welcome message only once, even if they rejoin the channel"
+	case rejoinPolicyAfterDays:
+		return fmt.Sprintf("users rejoining the channel receive the welcome message again if they last received it at least %d days ago", welcome.RejoinAfterDays)
+	default:
+		return "users receive the welcome message every time they join the channel"
+	}
 }
 
 func (p *Plugin) executeCommandGetWelcome(args *model.CommandArgs) {
-	key := fmt.Sprintf("%s%s", welcomebotChannelWelcomeKey, args.ChannelId)
-	data, appErr := p.API.KVGet(key)
-	if appErr != nil {
-		p.postCommandResponse(args, "error occurred while retrieving the welcome message for the chanel: `%s`", appErr)
+	welcome, err := p.getChannelWelcome(args.ChannelId)
+	if err != nil {
+		p.postCommandResponse(args, "error occurred while retrieving the welcome message for the chanel: `%s`", err)
+		return
+	}
+
+	translations, err := p.getChannelWelcomeTranslations(args.ChannelId)
+	if err != nil {
+		p.postCommandResponse(args, "error occurred while retrieving the translations of the welcome message for the chanel: `%s`", err)
 		return
 	}
 
-	if data == nil {
+	if welcome == nil && len(translations) == 0 {
 		p.postCommandResponse(args, "welcome message has not been set yet")
 		return
 	}
+	if welcome == nil {
+		welcome = &ChannelWelcomeRecord{}
+	}
+
+	var str strings.Builder
+	str.WriteString(fmt.Sprintf("Welcome message is:\n%s", welcome.Message))
+	str.WriteString(fmt.Sprintf("\n\nDelivery mode: `%s`", welcome.getDeliveryMode()))
+	if welcome.DelayInSeconds > 0 {
+		str.WriteString(fmt.Sprintf(", sent %d seconds after joining the channel", welcome.DelayInSeconds))
+	}
+	str.WriteString(fmt.Sprintf("\nRejoins: %s", formatChannelRejoinPolicy(welcome)))
+	if welcome.AttachmentMessage != "" {
+		str.WriteString(fmt.Sprintf("\n\nAttachment:\n%s", welcome.AttachmentMessage))
+	}
+	if len(welcome.Actions) > 0 {
+		actions, err := json.MarshalIndent(welcome.Actions, "", "  ")
+		if err != nil {
+			p.postCommandResponse(args, "error occurred while encoding the actions of the welcome message: `%s`", err)
+			return
+		}
+		str.WriteString(fmt.Sprintf("\n\nActions:\n```json\n%s\n```", actions))
+	}
+	locales := make([]string, 0, len(translations))
+	for locale := range translations {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	for _, locale := range locales {
+		translation := translations[locale]
+		if translation == nil {
+			continue
+		}
+		if translation.Message != "" {
+			str.WriteString(fmt.Sprintf("\n\nTranslation for `%s`:\n%s", locale, translation.Message))
+		}
+		if translation.AttachmentMessage != "" {
+			str.WriteString(fmt.Sprintf("\n\nAttachment translation for `%s`:\n%s", locale, translation.AttachmentMessage))
+		}
+	}
 
-	p.postCommandResponse(args, "Welcome message is:\n%s", string(data))
+	p.postCommandResponse(args, "%s", str.String())
 }
 
-func (p *Plugin) executeCommandDeleteWelcome(args *model.CommandArgs) {
-	key := fmt.Sprintf("%s%s", welcomebotChannelWelcomeKey, args.ChannelId)
-	data, appErr := p.API.KVGet(key)
+func (p *Plugin) executeCommandDeleteWelcome(parameters []string, args *model.CommandArgs) {
+	if len(parameters) == 1 && (parameters[0] == flagAttachment || parameters[0] == flagActions) {
+		p.executeCommandDeleteWelcomePart(parameters[0], args)
+		return
+	}
 
-	if appErr != nil {
-		p.postCommandResponse(args, "error occurred while retrieving the welcome message for the chanel: `%s`", appErr)
+	if len(parameters) == 1 {
+		locale := normalizeLocale(strings.TrimPrefix(parameters[0], flagLocale+"="))
+		translations, err := p.getChannelWelcomeTranslations(args.ChannelId)
+		if err != nil {
+			p.postCommandResponse(args, "error occurred while retrieving the translations of the welcome message for the chanel: `%s`", err)
+			return
+		}
+		if _, ok := translations[locale]; !ok {
+			p.postCommandResponse(args, "the welcome message has no `%s` translation", locale)
+			return
+		}
+
+		err = p.updateChannelWelcomeTranslation(args.ChannelId, locale, func(translation *ChannelWelcomeTranslation) {
+			*translation = ChannelWelcomeTranslation{}
+		})
+		if err != nil {
+			p.postCommandResponse(args, "error occurred while deleting the translation of the welcome message for the chanel: `%s`", err)
+			return
+		}
+
+		p.postCommandResponse(args, "the `%s` translation of the welcome message has been deleted", locale)
 		return
 	}
 
-	if data == nil {
+	welcome, err := p.getChannelWelcome(args.ChannelId)
+	if err != nil {
+		p.postCommandResponse(args, "error occurred while retrieving the welcome message for the chanel: `%s`", err)
+		return
+	}
+	translations, err := p.getChannelWelcomeTranslations(args.ChannelId)
+	if err != nil {
+		p.postCommandResponse(args, "error occurred while retrieving the translations of the welcome message for the chanel: `%s`", err)
+		return
+	}
+
+	if welcome == nil && len(translations) == 0 {
 		p.postCommandResponse(args, "welcome message has not been set yet")
 		return
 	}
 
-	if appErr := p.API.KVDelete(key); appErr != nil {
-		p.postCommandResponse(args, "error occurred while deleting the welcome message for the chanel: `%s`", appErr)
+	if err := p.deleteChannelWelcome(args.ChannelId); err != nil {
+		p.postCommandResponse(args, "error occurred while deleting the welcome message for the chanel: `%s`", err)
 		return
 	}
 
 	p.postCommandResponse(args, "welcome message has been deleted")
 }
 
+// executeCommandDeleteWelcomePart deletes the attachment or the actions of the welcome message of
+// the channel, keeping the message itself
+func (p *Plugin) executeCommandDeleteWelcomePart(flag string, args *model.CommandArgs) {
+	welcome, err := p.getChannelWelcome(args.ChannelId)
+	if err != nil {
+		p.postCommandResponse(args, "error occurred while retrieving the welcome message for the chanel: `%s`", err)
+		return
+	}
+
+	part := "attachment"
+	if flag == flagActions {
+		part = "actions"
+	}
+	if welcome == nil || (flag == flagAttachment && welcome.AttachmentMessage == "") || (flag == flagActions && len(welcome.Actions) == 0) {
+		p.postCommandResponse(args, "the welcome message has no %s", part)
+		return
+	}
+
+	response := "the attachment of the welcome message has been deleted"
+	if flag == flagAttachment {
+		welcome.AttachmentMessage = ""
+	} else {
+		welcome.Actions = nil
+		response = "the actions of the welcome message have been deleted"
+	}
+	if err := p.setChannelWelcome(args.ChannelId, welcome); err != nil {
+		p.postCommandResponse(args, "error occurred while storing the welcome message for the chanel: `%s`", err)
+		return
+	}
+
+	p.postCommandResponse(args, "%s", response)
+}
+
+func (p *Plugin) executeCommandResetChannelWelcome(username string, args *model.CommandArgs) {
+	user, appErr := p.API.GetUserByUsername(strings.TrimPrefix(username, "@"))
+	if appErr != nil {
+		p.postCommandResponse(args, "user `%s` has not been found", username)
+		return
+	}
+
+	if err := p.resetChannelDelivery(user.Id, args.ChannelId); err != nil {
+		p.postCommandResponse(args, "error occurred while resetting the welcome state of `%s` for the chanel: `%s`", username, err)
+		return
+	}
+
+	p.postCommandResponse(args, "@%s will receive the welcome message of the channel on their next join", user.Username)
+}
+
+func (p *Plugin) executeCommandHistory(username string, args *model.CommandArgs) {
+	user, appErr := p.API.GetUserByUsername(strings.TrimPrefix(username, "@"))
+	if appErr != nil {
+		p.postCommandResponse(args, "user `%s` has not been found", username)
+		return
+	}
+
+	events, err := p.getHistory(user.Id)
+	if err != nil {
+		p.postCommandResponse(args, "error occurred while retrieving the history of `%s`: `%s`", username, err)
+		return
+	}
+
+	p.postCommandResponse(args, "%s", p.formatHistory(user, events))
+}
+
+func (p *Plugin) executeCommandResend(parameters []string, args *model.CommandArgs) {
+	positional, flags := parseFlags(parameters)
+	options := welcomeOptions{Force: true}
+	for name := range flags {
+		if name != flagSkipAutomatic {
+			p.postCommandResponse(args, "unknown option `%s`", name)
+			return
+		}
+		options.SkipAutomaticActions = true
+	}
+	if len(positional) < 2 {
+		p.postCommandResponse(args, "Please specify a team and at least one user to resend the welcome messages to.")
+		return
+	}
+
+	teamName := positional[0]
+	team, appErr := p.API.GetTeamByName(strings.ToLower(teamName))
+	if appErr != nil {
+		p.postCommandResponse(args, "team `%s` has not been found", teamName)
+		return
+	}
+
+	var str strings.Builder
+	for _, username := range positional[1:] {
+		username = strings.TrimPrefix(username, "@")
+		user, appErr := p.API.GetUserByUsername(username)
+		if appErr != nil {
+			str.WriteString(fmt.Sprintf("\n * @%s: user has not been found", username))
+			continue
+		}
+		if member, appErr := p.API.GetTeamMember(team.Id, user.Id); appErr != nil || member.DeleteAt > 0 {
+			str.WriteString(fmt.Sprintf("\n * @%s: user is not a member of the team", username))
+			continue
+		}
+
+		data := p.constructMessageTemplate(user.Id, team.Id)
+		if data == nil {
+			str.WriteString(fmt.Sprintf("\n * @%s: failed to prepare the welcome messages, see the server logs", username))
+			continue
+		}
+
+		scheduled := p.startWelcomeFlow(data, options)
+		str.WriteString(fmt.Sprintf("\n * @%s: %d welcome message(s) scheduled", username, scheduled))
+	}
+
+	p.postCommandResponse(args, "Resending the welcome messages of team `%s`:%s", team.Name, str.String())
+}
+
+func (p *Plugin) executeCommandBackfill(parameters []string, args *model.CommandArgs) {
+	positional, flags := parseFlags(parameters)
+	if len(positional) != 1 {
+		p.postCommandResponse(args, "Please specify a team to backfill.")
+		return
+	}
+
+	options, err := parseBackfillOptions(flags)
+	if err != nil {
+		p.postCommandResponse(args, "%s", err)
+		return
+	}
+
+	teamName := positional[0]
+	team, appErr := p.API.GetTeamByName(strings.ToLower(teamName))
+	if appErr != nil {
+		p.postCommandResponse(args, "team `%s` has not been found", teamName)
+		return
+	}
+
+	started, err := p.startBackfill(team, options, args)
+	if err != nil {
+		p.postCommandResponse(args, "error occurred while starting the backfill: `%s`", err)
+		return
+	}
+	if !started {
+		p.postCommandResponse(args, "a backfill of team `%s` is already running", team.Name)
+	}
+}
+
+func (p *Plugin) executeCommandTeamWelcome(parameters []string, args *model.CommandArgs) {
+	switch parameters[0] {
+	case teamWelcomeTriggerCreate:
+		if err := p.openTeamWelcomeDialog(args.TriggerId, &ConfigMessage{}); err != nil {
+			p.postCommandResponse(args, "error occurred while opening the dialog: `%s`", err)
+		}
+	case teamWelcomeTriggerList:
+		var messages []*ConfigMessage
+		for _, message := range p.getWelcomeMessages() {
+			if p.canManageTeamWelcome(args.UserId, message.TeamName) {
+				messages = append(messages, message)
+			}
+		}
+		p.postCommandResponse(args, "%s", formatTeamWelcomeList(messages))
+	case teamWelcomeTriggerEdit, teamWelcomeTriggerDelete:
+		id := parameters[1]
+		message, err := p.getStoredWelcomeMessage(id)
+		if err != nil {
+			p.postCommandResponse(args, "error occurred while retrieving the team welcome message: `%s`", err)
+			return
+		}
+		if message == nil {
+			if p.getWelcomeMessageByID(id) != nil {
+				p.postCommandResponse(args, "team welcome message `%s` is defined in config.json and can only be changed there", id)
+				return
+			}
+			p.postCommandResponse(args, "team welcome message `%s` has not been found", id)
+			return
+		}
+		if !p.canManageTeamWelcome(args.UserId, message.TeamName) {
+			p.postCommandResponse(args, "Only system admins and admins of team `%s` can manage its welcome messages.", message.TeamName)
+			return
+		}
+
+		if parameters[0] == teamWelcomeTriggerEdit {
+			if err := p.openTeamWelcomeDialog(args.TriggerId, message); err != nil {
+				p.postCommandResponse(args, "error occurred while opening the dialog: `%s`", err)
+			}
+			return
+		}
+
+		if _, err := p.deleteStoredWelcomeMessage(id); err != nil {
+			p.postCommandResponse(args, "error occurred while deleting the team welcome message: `%s`", err)
+			return
+		}
+		p.postCommandResponse(args, "team welcome message `%s` has been deleted", id)
+	}
+}
+
+func (p *Plugin) executeCommandVariants(id string, args *model.CommandArgs) {
+	message := p.getWelcomeMessageByID(id)
+	if message == nil {
+		p.postCommandResponse(args, "team welcome message `%s` has not been found", id)
+		return
+	}
+	if !p.canManageTeamWelcome(args.UserId, message.TeamName) {
+		p.postCommandResponse(args, "Only system admins and admins of team `%s` can see the results of its welcome messages.", message.TeamName)
+		return
+	}
+
+	stats, err := p.getVariantStats(message.ID)
+	if err != nil {
+		p.postCommandResponse(args, "error occurred while retrieving the statistics of the variants: `%s`", err)
+		return
+	}
+	if len(message.Variants) == 0 && len(stats) == 0 {
+		p.postCommandResponse(args, "team welcome message `%s` has no variants", id)
+		return
+	}
+
+	p.postCommandResponse(args, "%s", formatVariantStats(message, stats))
+}
+
+func (p *Plugin) executeCommandStats(parameters []string, args *model.CommandArgs) {
+	positional, flags := parseFlags(parameters)
+	for name := range flags {
+		if name != flagSince {
+			p.postCommandResponse(args, "unknown option `%s`", name)
+			return
+		}
+	}
+
+	since, err := parseStatsSince(flags[flagSince])
+	if err != nil {
+		p.postCommandResponse(args, "invalid value for `%s`: %s", flagSince, err)
+		return
+	}
+
+	var team *model.Team
+	if len(positional) == 1 {
+		var appErr *model.AppError
+		if team, appErr = p.API.GetTeamByName(strings.ToLower(positional[0])); appErr != nil {
+			p.postCommandResponse(args, "team `%s` has not been found", positional[0])
+			return
+		}
+		if !p.canManageTeamWelcome(args.UserId, team.Name) {
+			p.postCommandResponse(args, "Only system admins and admins of team `%s` can see its statistics.", team.Name)
+			return
+		}
+	} else if !p.isSysadmin(args.UserId) {
+		p.postCommandResponse(args, "Only system admins can see the statistics of all the teams, please specify a team.")
+		return
+	}
+
+	report, err := p.getStatsReport(team, since)
+	if err != nil {
+		p.postCommandResponse(args, "error occurred while retrieving the statistics: `%s`", err)
+		return
+	}
+
+	p.postCommandResponse(args, "%s", formatStatsReport(report))
+}
+
+func (p *Plugin) executeCommandExport(parameters []string, args *model.CommandArgs) {
+	format := exportFormatJSON
+	if len(parameters) == 1 {
+		format = parameters[0]
+	}
+
+	if err := p.postExport(args.UserId, format); err != nil {
+		p.postCommandResponse(args, "error occurred while exporting the welcome messages: `%s`", err)
+		return
+	}
+
+	p.postCommandResponse(args, "The welcome messages have been exported to your direct channel with @%s.", botUsername)
+}
+
+func (p *Plugin) executeCommandImport(parameters []string, args *model.CommandArgs) {
+	positional, flags := parseFlags(parameters)
+	if len(positional) > 0 {
+		p.postCommandResponse(args, "`import` command does not accept any extra parameters")
+		return
+	}
+
+	mode := importModeMerge
+	dryRun := false
+	for name, value := range flags {
+		switch {
+		case name == flagMode && (value == importModeMerge || value == importModeReplace):
+			mode = value
+		case name == flagDryRun:
+			dryRun = true
+		default:
+			p.postCommandResponse(args, "unknown option `%s`", name)
+			return
+		}
+	}
+
+	fileInfo, data, err := p.findImportFile(args.UserId, args.ChannelId)
+	if err != nil {
+		p.postCommandResponse(args, "error occurred while reading the file to import: `%s`", err)
+		return
+	}
+
+	exported, err := decodeExport(data, getImportFormat(fileInfo))
+	if err != nil {
+		p.postCommandResponse(args, "error occurred while decoding `%s`: `%s`", fileInfo.Name, err)
+		return
+	}
+
+	summary, err := p.importConfiguration(exported, args.UserId, mode, dryRun)
+	if err != nil {
+		p.postCommandResponse(args, "error occurred while importing `%s`: `%s`", fileInfo.Name, err)
+		return
+	}
+
+	if dryRun {
+		p.postCommandResponse(args, "Importing `%s` in %s mode would make the following changes:\n%s", fileInfo.Name, mode, summary)
+		return
+	}
+	p.postCommandResponse(args, "Imported `%s` in %s mode:\n%s", fileInfo.Name, mode, summary)
+}
+
+func (p *Plugin) executeCommandDoctor(parameters []string, args *model.CommandArgs) {
+	fix := len(parameters) == 1
+
+	checks, err := p.runDoctor(fix)
+	if err != nil {
+		p.postCommandResponse(args, "error occurred while checking the environment: `%s`", err)
+		return
+	}
+
+	p.postCommandResponse(args, "%s", formatDoctorReport(checks, fix))
+}
+
 func (p *Plugin) ExecuteCommand(_ *plugin.Context, args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
 	split := strings.Fields(args.Command)
 	command := split[0]
@@ -215,7 +881,12 @@ func (p *Plugin) ExecuteCommand(_ *plugin.Context, args *model.CommandArgs) (*mo
 		return &model.CommandResponse{}, nil
 	}
 	if !isSysadmin {
-		if action == commandTriggerSetChannelWelcome || action == commandTriggerGetChannelWelcome || action == commandTriggerDeleteChannelWelcome {
+		switch action {
+		case commandTriggerHistory, commandTriggerResend, commandTriggerBackfill, commandTriggerExport, commandTriggerImport, commandTriggerDoctor:
+			p.postCommandResponse(args, "The `/welcomebot %s` command can only be executed by system admins.", action)
+			return &model.CommandResponse{}, nil
+		}
+		if action == commandTriggerSetChannelWelcome || action == commandTriggerGetChannelWelcome || action == commandTriggerDeleteChannelWelcome || action == commandTriggerResetChannelWelcome {
 			if hasPermissionTo := p.API.HasPermissionToChannel(args.UserId, args.ChannelId, model.PermissionManageChannelRoles); !hasPermissionTo {
 				p.postCommandResponse(args, "The `/welcomebot %s` command can only be executed by system admins and channel admins.", action)
 				return &model.CommandResponse{}, nil
@@ -238,7 +909,37 @@ func (p *Plugin) ExecuteCommand(_ *plugin.Context, args *model.CommandArgs) (*mo
 		p.executeCommandGetWelcome(args)
 		return &model.CommandResponse{}, nil
 	case commandTriggerDeleteChannelWelcome:
-		p.executeCommandDeleteWelcome(args)
+		p.executeCommandDeleteWelcome(parameters, args)
+		return &model.CommandResponse{}, nil
+	case commandTriggerResetChannelWelcome:
+		p.executeCommandResetChannelWelcome(parameters[0], args)
+		return &model.CommandResponse{}, nil
+	case commandTriggerHistory:
+		p.executeCommandHistory(parameters[0], args)
+		return &model.CommandResponse{}, nil
+	case commandTriggerResend:
+		p.executeCommandResend(parameters, args)
+		return &model.CommandResponse{}, nil
+	case commandTriggerBackfill:
+		p.executeCommandBackfill(parameters, args)
+		return &model.CommandResponse{}, nil
+	case commandTriggerTeamWelcome:
+		p.executeCommandTeamWelcome(parameters, args)
+		return &model.CommandResponse{}, nil
+	case commandTriggerVariants:
+		p.executeCommandVariants(parameters[0], args)
+		return &model.CommandResponse{}, nil
+	case commandTriggerStats:
+		p.executeCommandStats(parameters, args)
+		return &model.CommandResponse{}, nil
+	case commandTriggerExport:
+		p.executeCommandExport(parameters, args)
+		return &model.CommandResponse{}, nil
+	case commandTriggerImport:
+		p.executeCommandImport(parameters, args)
+		return &model.CommandResponse{}, nil
+	case commandTriggerDoctor:
+		p.executeCommandDoctor(parameters, args)
 		return &model.CommandResponse{}, nil
 	case commandTriggerHelp:
 		fallthrough
@@ -254,7 +955,7 @@ func (p *Plugin) ExecuteCommand(_ *plugin.Context, args *model.CommandArgs) (*mo
 
 func getAutocompleteData() *model.AutocompleteData {
 	welcomebot := model.NewAutocompleteData("welcomebot", "[command]",
-		"Available commands: preview, help, list, set_channel_welcome, get_channel_welcome, delete_channel_welcome")
+		"Available commands: preview, help, list, set_channel_welcome, get_channel_welcome, delete_channel_welcome, reset_channel_welcome, history, resend, backfill, team_welcome, variants, stats, export, import, doctor")
 
 	preview := model.NewAutocompleteData("preview", "[team-name]", "Preview the welcome message for the given team name")
 	preview.AddTextArgument("Team name to preview welcome message", "[team-name]", "")
@@ -263,15 +964,74 @@ func getAutocompleteData() *model.AutocompleteData {
 	list := model.NewAutocompleteData("list", "", "Lists team welcome messages")
 	welcomebot.AddCommand(list)
 
-	setChannelWelcome := model.NewAutocompleteData("set_channel_welcome", "[welcome-message]", "Set the welcome message for the channel")
-	setChannelWelcome.AddTextArgument("Welcome message for the channel", "[welcome-message]", "")
+	setChannelWelcome := model.NewAutocompleteData("set_channel_welcome", "[--locale=language|--attachment|--actions|--delivery=mode|--delay=seconds|--rejoin=policy] [welcome-message]", "Set the welcome message for the channel, its translation for a language, its attachment, its actions or how it is delivered")
+	setChannelWelcome.AddTextArgument("Welcome message for the channel", "[--locale=language|--attachment|--actions|--delivery=mode|--delay=seconds|--rejoin=policy] [welcome-message]", "")
 	welcomebot.AddCommand(setChannelWelcome)
 
 	getChannelWelcome := model.NewAutocompleteData("get_channel_welcome", "", "Print the welcome message set for the channel")
 	welcomebot.AddCommand(getChannelWelcome)
 
-	deleteChannelWelcome := model.NewAutocompleteData("delete_channel_welcome", "", "Delete the welcome message for the channel")
+	deleteChannelWelcome := model.NewAutocompleteData("delete_channel_welcome", "[--locale=language|--attachment|--actions]", "Delete the welcome message for the channel, or its translation for a language, its attachment or its actions")
+	deleteChannelWelcome.AddTextArgument("Part of the welcome message to delete", "[--locale=language|--attachment|--actions]", "")
 	welcomebot.AddCommand(deleteChannelWelcome)
 
+	resetChannelWelcome := model.NewAutocompleteData("reset_channel_welcome", "[@username]", "Send the welcome message for the channel again to the user on their next join")
+	resetChannelWelcome.AddTextArgument("User whose welcome state should be reset", "[@username]", "")
+	welcomebot.AddCommand(resetChannelWelcome)
+
+	history := model.NewAutocompleteData("history", "[@username]", "Show the welcome history of the given user")
+	history.AddTextArgument("User whose welcome history should be shown", "[@username]", "")
+	history.RoleID = model.SystemAdminRoleId
+	welcomebot.AddCommand(history)
+
+	resend := model.NewAutocompleteData("resend", "[team-name] [@username...] [--skip-automatic]", "Send the welcome messages of the given team again to the given users")
+	resend.AddTextArgument("Team name, followed by the users to welcome again", "[team-name] [@username...] [--skip-automatic]", "")
+	resend.RoleID = model.SystemAdminRoleId
+	welcomebot.AddCommand(resend)
+
+	backfill := model.NewAutocompleteData("backfill", "[team-name] [--joined-after=YYYY-MM-DD] [--joined-before=YYYY-MM-DD] [--exclude-guests] [--rate=users-per-minute] [--dry-run]", "Send the welcome messages of the given team to its existing members")
+	backfill.AddTextArgument("Team name, followed by the options", "[team-name] [--joined-after=YYYY-MM-DD] [--joined-before=YYYY-MM-DD] [--exclude-guests] [--rate=users-per-minute] [--dry-run]", "")
+	backfill.RoleID = model.SystemAdminRoleId
+	welcomebot.AddCommand(backfill)
+
+	export := model.NewAutocompleteData("export", "[json|yaml]", "Send a file with all the team and channel welcome messages")
+	export.AddStaticListArgument("Format of the file", false, []model.AutocompleteListItem{
+		{Item: exportFormatJSON, HelpText: "Export as JSON"},
+		{Item: exportFormatYAML, HelpText: "Export as YAML"},
+	})
+	export.RoleID = model.SystemAdminRoleId
+	welcomebot.AddCommand(export)
+
+	importData := model.NewAutocompleteData("import", "[--mode=merge|replace] [--dry-run]", "Import the welcome messages of the last file you uploaded in the current channel")
+	importData.AddTextArgument("Import options", "[--mode=merge|replace] [--dry-run]", "")
+	importData.RoleID = model.SystemAdminRoleId
+	welcomebot.AddCommand(importData)
+
+	doctor := model.NewAutocompleteData("doctor", "[--fix]", "Check the environment for problems preventing the delivery of the welcome messages")
+	doctor.AddStaticListArgument("Whether to repair the problems", false, []model.AutocompleteListItem{
+		{Item: flagFix, HelpText: "Repair the problems the plugin can fix itself"},
+	})
+	doctor.RoleID = model.SystemAdminRoleId
+	welcomebot.AddCommand(doctor)
+
+	teamWelcome := model.NewAutocompleteData("team_welcome", "[create|edit|delete|list]", "Manage the team welcome messages")
+	teamWelcome.AddCommand(model.NewAutocompleteData(teamWelcomeTriggerCreate, "", "Create a team welcome message"))
+	teamWelcomeEdit := model.NewAutocompleteData(teamWelcomeTriggerEdit, "[id]", "Edit a team welcome message")
+	teamWelcomeEdit.AddTextArgument("ID of the team welcome message", "[id]", "")
+	teamWelcome.AddCommand(teamWelcomeEdit)
+	teamWelcomeDelete := model.NewAutocompleteData(teamWelcomeTriggerDelete, "[id]", "Delete a team welcome message")
+	teamWelcomeDelete.AddTextArgument("ID of the team welcome message", "[id]", "")
+	teamWelcome.AddCommand(teamWelcomeDelete)
+	teamWelcome.AddCommand(model.NewAutocompleteData(teamWelcomeTriggerList, "", "List the team welcome messages"))
+	welcomebot.AddCommand(teamWelcome)
+
+	variants := model.NewAutocompleteData("variants", "[id]", "Compare the click-through of the variants of a team welcome message")
+	variants.AddTextArgument("ID of the team welcome message", "[id]", "")
+	welcomebot.AddCommand(variants)
+
+	stats := model.NewAutocompleteData("stats", "[team-name] [--since=YYYY-MM-DD]", "Show the onboarding statistics of a team")
+	stats.AddTextArgument("Team name, followed by the first day to report", "[team-name] [--since=YYYY-MM-DD]", "")
+	welcomebot.AddCommand(stats)
+
 	return welcomebot
 }
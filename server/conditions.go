@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// Authentication services a condition can match. Users signing in with an email and a password
+// have the email service.
+var conditionAuthServices = []string{
+	model.UserAuthServiceEmail,
+	model.UserAuthServiceSaml,
+	model.UserAuthServiceLdap,
+	model.ServiceGitlab,
+	model.ServiceGoogle,
+	model.ServiceOffice365,
+	model.ServiceOpenid,
+}
+
+// ConfigMessageConditions restricts a welcome message to an audience. The user must match every
+// condition which is set, and matches a condition when they match any of its values.
+type ConfigMessageConditions struct {
+	// Domains of the email address, e.g. example.com
+	EmailDomains []string `json:",omitempty"`
+
+	// Authentication services: email, saml, ldap, gitlab, google, office365 or openid
+	AuthServices []string `json:",omitempty"`
+
+	// System roles, e.g. system_user or system_guest
+	SystemRoles []string `json:",omitempty"`
+
+	// Team roles in the team being joined, e.g. team_user or team_admin
+	TeamRoles []string `json:",omitempty"`
+
+	// Positions, compared case-insensitively
+	Positions []string `json:",omitempty"`
+
+	// Regular expressions matching the username
+	UsernamePatterns []string `json:",omitempty"`
+
+	// Regular expressions matching the email address
+	EmailPatterns []string `json:",omitempty"`
+
+	// Names or display names of the groups of the user
+	Groups []string `json:",omitempty"`
+}
+
+// check lists the problems of the conditions
+func (c *ConfigMessageConditions) check() []string {
+	var problems []string
+	for _, service := range c.AuthServices {
+		if !containsFold(conditionAuthServices, service) {
+			problems = append(problems, fmt.Sprintf("unknown auth service `%s` in the conditions, expected one of `%s`", service, strings.Join(conditionAuthServices, "`, `")))
+		}
+	}
+	for _, pattern := range append(append([]string{}, c.UsernamePatterns...), c.EmailPatterns...) {
+		if _, err := regexp.Compile(pattern); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid pattern `%s` in the conditions: %s", pattern, err.Error()))
+		}
+	}
+
+	return problems
+}
+
+// matches tells whether the user matches the conditions, given their roles in the team and their
+// groups. Invalid patterns match no user.
+func (c *ConfigMessageConditions) matches(user *model.User, teamRoles []string, groups []*model.Group) bool {
+	if c == nil {
+		return true
+	}
+
+	if len(c.EmailDomains) > 0 {
+		_, domain, _ := strings.Cut(user.Email, "@")
+		matched := false
+		for _, emailDomain := range c.EmailDomains {
+			if strings.EqualFold(strings.TrimPrefix(emailDomain, "@"), domain) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if len(c.AuthServices) > 0 {
+		service := user.AuthService
+		if service == "" {
+			service = model.UserAuthServiceEmail
+		}
+		if !containsFold(c.AuthServices, service) {
+			return false
+		}
+	}
+
+	if len(c.SystemRoles) > 0 && !containsAny(user.GetRoles(), c.SystemRoles) {
+		return false
+	}
+
+	if len(c.TeamRoles) > 0 && !containsAny(teamRoles, c.TeamRoles) {
+		return false
+	}
+
+	if len(c.Positions) > 0 && !containsFold(c.Positions, strings.TrimSpace(user.Position)) {
+		return false
+	}
+
+	if len(c.UsernamePatterns) > 0 && !matchesAnyPattern(c.UsernamePatterns, user.Username) {
+		return false
+	}
+
+	if len(c.EmailPatterns) > 0 && !matchesAnyPattern(c.EmailPatterns, user.Email) {
+		return false
+	}
+
+	if len(c.Groups) > 0 {
+		matched := false
+		for _, group := range groups {
+			if containsFold(c.Groups, group.GetName()) || containsFold(c.Groups, group.DisplayName) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchesConditions tells whether the user matches the conditions of the message. The team
+// membership and the groups of the user are only loaded when the conditions need them.
+func (p *Plugin) matchesConditions(message *ConfigMessage, user *model.User, team *model.Team) (bool, error) {
+	conditions := message.Conditions
+	if conditions == nil {
+		return true, nil
+	}
+
+	var teamRoles []string
+	if len(conditions.TeamRoles) > 0 {
+		member, appErr := p.API.GetTeamMember(team.Id, user.Id)
+		if appErr != nil {
+			return false, errors.Wrap(appErr, "failed to get the team member")
+		}
+		teamRoles = getTeamMemberRoles(member)
+	}
+
+	var groups []*model.Group
+	if len(conditions.Groups) > 0 {
+		var appErr *model.AppError
+		if groups, appErr = p.API.GetGroupsForUser(user.Id); appErr != nil {
+			return false, errors.Wrap(appErr, "failed to get the groups of the user")
+		}
+	}
+
+	return conditions.matches(user, teamRoles, groups), nil
+}
+
+// getTeamMemberRoles lists the roles of a team member, including the ones granted by the scheme of
+// the team
+func getTeamMemberRoles(member *model.TeamMember) []string {
+	roles := member.GetRoles()
+	if member.SchemeGuest {
+		roles = append(roles, model.TeamGuestRoleId)
+	}
+	if member.SchemeUser {
+		roles = append(roles, model.TeamUserRoleId)
+	}
+	if member.SchemeAdmin {
+		roles = append(roles, model.TeamAdminRoleId)
+	}
+
+	return roles
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(strings.TrimSpace(v), value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsAny(values, wanted []string) bool {
+	for _, value := range wanted {
+		if containsFold(values, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchesAnyPattern(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if re, err := regexp.Compile(pattern); err == nil && re.MatchString(value) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestConditionsMatches(t *testing.T) {
+	user := &model.User{
+		Username:    "ext-alice",
+		Email:       "alice@Example.com",
+		AuthService: model.UserAuthServiceSaml,
+		Roles:       "system_user",
+		Position:    "Software Engineer",
+	}
+	groups := []*model.Group{{Name: model.NewString("engineering"), DisplayName: "Engineering"}}
+	teamRoles := []string{model.TeamUserRoleId}
+
+	for _, tc := range []struct {
+		name       string
+		conditions *ConfigMessageConditions
+		expected   bool
+	}{
+		{name: "no conditions", conditions: nil, expected: true},
+		{name: "empty conditions", conditions: &ConfigMessageConditions{}, expected: true},
+		{name: "email domain", conditions: &ConfigMessageConditions{EmailDomains: []string{"other.com", "example.com"}}, expected: true},
+		{name: "other email domain", conditions: &ConfigMessageConditions{EmailDomains: []string{"other.com"}}, expected: false},
+		{name: "auth service", conditions: &ConfigMessageConditions{AuthServices: []string{"SAML"}}, expected: true},
+		{name: "other auth service", conditions: &ConfigMessageConditions{AuthServices: []string{"email"}}, expected: false},
+		{name: "system role", conditions: &ConfigMessageConditions{SystemRoles: []string{"system_user"}}, expected: true},
+		{name: "other system role", conditions: &ConfigMessageConditions{SystemRoles: []string{"system_admin"}}, expected: false},
+		{name: "team role", conditions: &ConfigMessageConditions{TeamRoles: []string{"team_user"}}, expected: true},
+		{name: "other team role", conditions: &ConfigMessageConditions{TeamRoles: []string{"team_admin"}}, expected: false},
+		{name: "position", conditions: &ConfigMessageConditions{Positions: []string{"software engineer"}}, expected: true},
+		{name: "other position", conditions: &ConfigMessageConditions{Positions: []string{"Sales"}}, expected: false},
+		{name: "username pattern", conditions: &ConfigMessageConditions{UsernamePatterns: []string{"^ext-"}}, expected: true},
+		{name: "other username pattern", conditions: &ConfigMessageConditions{UsernamePatterns: []string{"^int-"}}, expected: false},
+		{name: "invalid pattern", conditions: &ConfigMessageConditions{UsernamePatterns: []string{"("}}, expected: false},
+		{name: "email pattern", conditions: &ConfigMessageConditions{EmailPatterns: []string{"(?i)@example\\.com$"}}, expected: true},
+		{name: "group name", conditions: &ConfigMessageConditions{Groups: []string{"engineering"}}, expected: true},
+		{name: "group display name", conditions: &ConfigMessageConditions{Groups: []string{"engineering", "Sales"}}, expected: true},
+		{name: "other group", conditions: &ConfigMessageConditions{Groups: []string{"sales"}}, expected: false},
+		{
+			name: "all conditions must match",
+			conditions: &ConfigMessageConditions{
+				EmailDomains: []string{"example.com"},
+				AuthServices: []string{"ldap"},
+			},
+			expected: false,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := tc.conditions.matches(user, teamRoles, groups); actual != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestConditionsMatchesEmailAuthService(t *testing.T) {
+	conditions := &ConfigMessageConditions{AuthServices: []string{"email"}}
+	if !conditions.matches(&model.User{}, nil, nil) {
+		t.Error("expected a user without auth service to match the email auth service")
+	}
+}
@@ -1,8 +1,20 @@
 package main
 
+import (
+	"fmt"
+	"strings"
+)
+
 const (
 	actionTypeAutomatic = "automatic"
 	actionTypeButton    = "button"
+
+	rejoinPolicyAlways    = "always"
+	rejoinPolicyNever     = "never"
+	rejoinPolicyAfterDays = "after_days"
+
+	messageSourceConfig = "config"
+	messageSourceStore  = "store"
 )
 
 // ConfigMessageAction are actions that can be taken from the welcome message
@@ -21,10 +33,28 @@ type ConfigMessageAction struct {
 
 	// The names of the channels that a users should be added to
 	ChannelsAddedTo []string
+
+	// Translations of the action by locale, e.g. fr or pt-BR
+	Translations map[string]*ConfigActionTranslation
+}
+
+// ConfigActionTranslation is the translation of an action for a locale
+type ConfigActionTranslation struct {
+	ActionDisplayName       string
+	ActionSuccessfulMessage []string
+}
+
+// ConfigMessageTranslation is the translation of a message for a locale
+type ConfigMessageTranslation struct {
+	Message           []string
+	AttachmentMessage []string
 }
 
 // ConfigMessage represents the message to send in channel
 type ConfigMessage struct {
+	// A stable identifier used to match scheduled deliveries with this message. Defaults to the team name and the position of the message for that team
+	ID string
+
 	// This message will fire when it matches the supplied team
 	TeamName string
 
@@ -42,16 +72,241 @@ type ConfigMessage struct {
 
 	// Whether or not to include guest users
 	IncludeGuests bool
+
+	// Restricts the message to the users matching these conditions
+	Conditions *ConfigMessageConditions `json:",omitempty"`
+
+	// Whether the message is sent again to users rejoining the team: always (default), never or after_days
+	RejoinPolicy string
+
+	// Number of days since the last delivery after which rejoining users receive the message again, for the after_days policy
+	RejoinAfterDays int
+
+	// Follow-up messages sent on their own schedule, e.g. for drip onboarding sequences
+	Steps []*ConfigMessageStep
+
+	// Translations of the message by locale, e.g. fr or pt-BR
+	Translations map[string]*ConfigMessageTranslation
+
+	// Alternative contents of the top level message, each user receiving one of them
+	Variants []*ConfigMessageVariant `json:",omitempty"`
+
+	// The variant the content comes from, set by withVariant
+	Variant string `json:"-"`
+
+	// Where the message is defined: config.json or the KV store
+	Source string `json:"-"`
+}
+
+// ConfigMessageStep is a follow-up message of a ConfigMessage
+type ConfigMessageStep struct {
+	// Number of seconds after joining the team to wait before sending this step
+	DelayInSeconds int
+
+	// The message to send.  This is a go template that can access any member in MessageTemplate
+	Message []string
+
+	// The message to send as a slack attachment.  This is a go template that can access any member in MessageTemplate
+	AttachmentMessage []string
+
+	// Actions that can be taken with this step
+	Actions []*ConfigMessageAction
+
+	// Translations of the step by locale, e.g. fr or pt-BR
+	Translations map[string]*ConfigMessageTranslation
+}
+
+// ConfigMessageVariant is an alternative content of the top level message, to compare how users
+// react to different welcomes
+type ConfigMessageVariant struct {
+	// The name of the variant, used to report its results
+	Name string
+
+	// The share of the users receiving this variant, relative to the weights of the other variants
+	Weight int
+
+	// The message to send.  This is a go template that can access any member in MessageTemplate
+	Message []string
+
+	// The message to send as a slack attachment.  This is a go template that can access any member in MessageTemplate
+	AttachmentMessage []string
+
+	// Actions that can be taken with this variant
+	Actions []*ConfigMessageAction
+
+	// Translations of the variant by locale, e.g. fr or pt-BR
+	Translations map[string]*ConfigMessageTranslation
+}
+
+// getSteps lists the posts to deliver for this message. The top level message comes first, unless
+// it has no content and follow-up steps are defined.
+func (m *ConfigMessage) getSteps() []*ConfigMessageStep {
+	steps := make([]*ConfigMessageStep, 0, len(m.Steps)+1)
+	if len(m.Steps) == 0 || len(m.Message) > 0 || len(m.AttachmentMessage) > 0 || len(m.Actions) > 0 {
+		steps = append(steps, &ConfigMessageStep{
+			DelayInSeconds:    m.DelayInSeconds,
+			Message:           m.Message,
+			AttachmentMessage: m.AttachmentMessage,
+			Actions:           m.Actions,
+			Translations:      m.Translations,
+		})
+	}
+
+	return append(steps, m.Steps...)
+}
+
+// forStep returns a copy of the message with the content of the given step
+func (m ConfigMessage) forStep(step *ConfigMessageStep) ConfigMessage {
+	m.DelayInSeconds = step.DelayInSeconds
+	m.Message = step.Message
+	m.AttachmentMessage = step.AttachmentMessage
+	m.Actions = step.Actions
+	m.Translations = step.Translations
+	m.Steps = nil
+
+	return m
+}
+
+// getVariant returns the variant with the given name, or nil
+func (m *ConfigMessage) getVariant(name string) *ConfigMessageVariant {
+	for _, variant := range m.Variants {
+		if variant != nil && variant.Name == name {
+			return variant
+		}
+	}
+
+	return nil
+}
+
+// withVariant returns a copy of the message with the top level content of the given variant. The
+// message is returned unchanged if the variant does not exist.
+func (m ConfigMessage) withVariant(name string) ConfigMessage {
+	variant := m.getVariant(name)
+	if variant == nil {
+		return m
+	}
+
+	m.Message = variant.Message
+	m.AttachmentMessage = variant.AttachmentMessage
+	m.Actions = variant.Actions
+	m.Translations = variant.Translations
+	m.Variant = variant.Name
+	m.Variants = nil
+
+	return m
+}
+
+// getVariantMessages lists the message with the content of each of its variants, or only the
+// message itself when it has no variants
+func (m ConfigMessage) getVariantMessages() []ConfigMessage {
+	if len(m.Variants) == 0 {
+		return []ConfigMessage{m}
+	}
+
+	messages := make([]ConfigMessage, 0, len(m.Variants))
+	for _, variant := range m.Variants {
+		if variant != nil {
+			messages = append(messages, m.withVariant(variant.Name))
+		}
+	}
+
+	return messages
+}
+
+// getVariantSteps lists the top level content of every variant as a step
+func (m *ConfigMessage) getVariantSteps() []*ConfigMessageStep {
+	steps := make([]*ConfigMessageStep, 0, len(m.Variants))
+	for _, variant := range m.Variants {
+		if variant == nil {
+			continue
+		}
+		steps = append(steps, &ConfigMessageStep{
+			DelayInSeconds:    m.DelayInSeconds,
+			Message:           variant.Message,
+			AttachmentMessage: variant.AttachmentMessage,
+			Actions:           variant.Actions,
+			Translations:      variant.Translations,
+		})
+	}
+
+	return steps
+}
+
+// withoutAutomaticActions returns a copy of the message with its button actions only
+func (m ConfigMessage) withoutAutomaticActions() ConfigMessage {
+	actions := make([]*ConfigMessageAction, 0, len(m.Actions))
+	for _, action := range m.Actions {
+		if action.ActionType != actionTypeAutomatic {
+			actions = append(actions, action)
+		}
+	}
+	m.Actions = actions
+
+	return m
+}
+
+// isEmpty tells whether the message has nothing to post: no message, attachment message, actions,
+// steps or variants
+func (m *ConfigMessage) isEmpty() bool {
+	return len(m.Message) == 0 && len(m.AttachmentMessage) == 0 && len(m.Actions) == 0 && len(m.Steps) == 0 && len(m.Variants) == 0
+}
+
+// getActions lists the actions of the message and of all its steps and variants
+func (m *ConfigMessage) getActions() []*ConfigMessageAction {
+	var actions []*ConfigMessageAction
+	for _, step := range append(m.getSteps(), m.getVariantSteps()...) {
+		if step != nil {
+			actions = append(actions, step.Actions...)
+		}
+	}
+
+	return actions
 }
 
 // Configuration from config.json
 type Configuration struct {
 	WelcomeMessages []*ConfigMessage
+
+	// Token granting access to the metrics endpoint
+	MetricsToken string
 }
 
-// List of the welcome messages from the configuration
+// List of the welcome messages from the configuration, followed by the ones managed from inside
+// Mattermost
 func (p *Plugin) getWelcomeMessages() []*ConfigMessage {
-	return p.welcomeMessages.Load().([]*ConfigMessage)
+	return p.getSnapshot().messages
+}
+
+// Find a welcome message by its ID
+func (p *Plugin) getWelcomeMessageByID(id string) *ConfigMessage {
+	return p.getSnapshot().messagesByID[id]
+}
+
+// List of the welcome messages of a team
+func (p *Plugin) getTeamWelcomeMessages(teamName string) []*ConfigMessage {
+	return p.getSnapshot().messagesByTeam[teamName]
+}
+
+// Find the action of a team matching a button click
+func (p *Plugin) getTeamWelcomeAction(teamName, actionName string) *ConfigMessageAction {
+	return p.getSnapshot().actions[actionKey{teamName: teamName, actionName: actionName}]
+}
+
+// Find the action of a variant of a team welcome message matching a button click
+func (p *Plugin) getVariantAction(teamName, messageID, variant, actionName string) *ConfigMessageAction {
+	message := p.getWelcomeMessageByID(messageID)
+	if message == nil || message.TeamName != teamName || message.getVariant(variant) == nil {
+		return nil
+	}
+
+	variantMessage := message.withVariant(variant)
+	for _, action := range variantMessage.getActions() {
+		if action != nil && action.ActionName == actionName {
+			return action
+		}
+	}
+
+	return nil
 }
 
 // OnConfigurationChange is invoked when configuration changes may have been made.
@@ -63,7 +318,50 @@ func (p *Plugin) OnConfigurationChange() error {
 		return err
 	}
 
-	p.welcomeMessages.Store(c.WelcomeMessages)
+	assignMessageIDs(c.WelcomeMessages)
 
-	return nil
+	p.metricsToken.Store(c.MetricsToken)
+
+	p.snapshotLock.Lock()
+	defer p.snapshotLock.Unlock()
+
+	// Bad welcome messages in config.json are rejected as a whole, and the last good ones stay
+	// active. The messages managed from inside Mattermost are loaded regardless.
+	configMessages := c.WelcomeMessages
+	warnings, validationErr := p.validateWelcomeMessages(c.WelcomeMessages)
+	if len(warnings) > 0 {
+		p.API.LogWarn("Some welcome messages of the configuration refer to teams or channels which have not been found", "warnings", strings.Join(warnings, "; "))
+	}
+	if validationErr != nil {
+		p.API.LogError("The welcome messages of the configuration have been rejected", "err", validationErr.Error())
+		configMessages = p.getSnapshot().configMessages
+	}
+
+	stored, err := p.getStoredWelcomeMessages()
+	if err != nil {
+		p.API.LogError("failed to get stored welcome messages", "err", err.Error())
+		stored = p.getSnapshot().storedMessages
+	}
+
+	p.snapshot.Store(p.newConfigurationSnapshot(configMessages, stored))
+
+	return validationErr
+}
+
+// assignMessageIDs gives every message without an explicit ID one derived from its team and its
+// position among the messages of that team, and marks them as coming from config.json.
+func assignMessageIDs(messages []*ConfigMessage) {
+	positions := make(map[string]int)
+	for _, message := range messages {
+		if message == nil {
+			continue
+		}
+		position := positions[message.TeamName]
+		positions[message.TeamName]++
+
+		if message.ID == "" {
+			message.ID = fmt.Sprintf("%s-%d", message.TeamName, position)
+		}
+		message.Source = messageSourceConfig
+	}
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetSteps(t *testing.T) {
+	followUp := &ConfigMessageStep{Message: []string{"How is it going?"}, DelayInSeconds: 60}
+	action := &ConfigMessageAction{ActionType: actionTypeAutomatic, ActionName: "join"}
+
+	for name, tc := range map[string]struct {
+		message  *ConfigMessage
+		expected []*ConfigMessageStep
+	}{
+		"top level message only": {
+			message:  &ConfigMessage{Message: []string{"Hi"}, DelayInSeconds: 5},
+			expected: []*ConfigMessageStep{{Message: []string{"Hi"}, DelayInSeconds: 5}},
+		},
+		"empty message": {
+			message:  &ConfigMessage{},
+			expected: []*ConfigMessageStep{{}},
+		},
+		"top level message and steps": {
+			message:  &ConfigMessage{Message: []string{"Hi"}, Steps: []*ConfigMessageStep{followUp}},
+			expected: []*ConfigMessageStep{{Message: []string{"Hi"}}, followUp},
+		},
+		"top level actions and steps": {
+			message:  &ConfigMessage{Actions: []*ConfigMessageAction{action}, Steps: []*ConfigMessageStep{followUp}},
+			expected: []*ConfigMessageStep{{Actions: []*ConfigMessageAction{action}}, followUp},
+		},
+		"steps only": {
+			message:  &ConfigMessage{DelayInSeconds: 5, Steps: []*ConfigMessageStep{followUp}},
+			expected: []*ConfigMessageStep{followUp},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if steps := tc.message.getSteps(); !reflect.DeepEqual(steps, tc.expected) {
+				t.Errorf("expected %+v, got %+v", tc.expected, steps)
+			}
+		})
+	}
+}
+
+func TestForStep(t *testing.T) {
+	step := &ConfigMessageStep{
+		Message:           []string{"How is it going?"},
+		AttachmentMessage: []string{"Details"},
+		DelayInSeconds:    60,
+		Actions:           []*ConfigMessageAction{{ActionType: actionTypeButton, ActionName: "help"}},
+		Translations:      map[string]*ConfigMessageTranslation{"fr": {Message: []string{"Comment ça va ?"}}},
+	}
+	message := &ConfigMessage{
+		ID:           "message-id",
+		TeamName:     "team",
+		Message:      []string{"Hi"},
+		Translations: map[string]*ConfigMessageTranslation{"de": {Message: []string{"Hallo"}}},
+		Steps:        []*ConfigMessageStep{step},
+	}
+
+	stepMessage := message.forStep(step)
+	expected := ConfigMessage{
+		ID:                "message-id",
+		TeamName:          "team",
+		Message:           step.Message,
+		AttachmentMessage: step.AttachmentMessage,
+		DelayInSeconds:    step.DelayInSeconds,
+		Actions:           step.Actions,
+		Translations:      step.Translations,
+	}
+	if !reflect.DeepEqual(stepMessage, expected) {
+		t.Errorf("expected %+v, got %+v", expected, stepMessage)
+	}
+	if len(message.Steps) != 1 || message.Message[0] != "Hi" {
+		t.Errorf("expected the message to be unchanged, got %+v", message)
+	}
+}
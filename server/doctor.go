@@ -0,0 +1,304 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const flagFix = "--fix"
+
+// doctorCheck is the result of one check of the environment
+type doctorCheck struct {
+	// What has been checked
+	Name string
+
+	// Whether the check passed
+	Passed bool
+
+	// What is wrong, and how to fix it, when the check failed
+	Problem    string
+	Suggestion string
+
+	// Repairs the problem, if the plugin can do it itself
+	fix func() error
+
+	// Set once the problem has been repaired
+	Fixed bool
+}
+
+// runDoctor checks that the environment allows the configured team and channel welcome messages to
+// be delivered. Problems the plugin can repair itself are fixed when fix is set.
+func (p *Plugin) runDoctor(fix bool) ([]*doctorCheck, error) {
+	config := p.API.GetConfig()
+
+	checks := p.checkSiteURL(config)
+
+	teams := make(map[string]*model.Team)
+	var teamNames []string
+	for _, message := range p.getWelcomeMessages() {
+		if _, ok := teams[message.TeamName]; ok {
+			continue
+		}
+		team, appErr := p.API.GetTeamByName(message.TeamName)
+		if appErr != nil {
+			checks = append(checks, &doctorCheck{
+				Name:       fmt.Sprintf("team `%s` exists", message.TeamName),
+				Problem:    "the team has not been found",
+				Suggestion: "fix the team name of its welcome messages, it must be the team handle used in URLs",
+			})
+		}
+		teams[message.TeamName] = team
+		teamNames = append(teamNames, message.TeamName)
+	}
+
+	for _, teamName := range teamNames {
+		team := teams[teamName]
+		if team == nil {
+			continue
+		}
+
+		checks = append(checks, p.checkTownSquare(team))
+		for _, message := range p.getWelcomeMessages() {
+			if message.TeamName == teamName {
+				checks = append(checks, p.checkActionChannels(team, message)...)
+			}
+		}
+	}
+
+	welcomes, err := p.listChannelWelcomes()
+	if err != nil {
+		return nil, err
+	}
+	channelIDs := make([]string, 0, len(welcomes))
+	for channelID := range welcomes {
+		channelIDs = append(channelIDs, channelID)
+	}
+	sort.Strings(channelIDs)
+
+	for _, channelID := range channelIDs {
+		check, team := p.checkWelcomeChannel(channelID)
+		checks = append(checks, check)
+		if team != nil {
+			if _, ok := teams[team.Name]; !ok {
+				teams[team.Name] = team
+				teamNames = append(teamNames, team.Name)
+			}
+		}
+	}
+
+	// The bot can only send direct messages to members of its teams when direct messages are
+	// restricted to teams
+	if config != nil && config.TeamSettings.RestrictDirectMessage != nil && *config.TeamSettings.RestrictDirectMessage == model.DirectMessageTeam {
+		for _, teamName := range teamNames {
+			if team := teams[teamName]; team != nil {
+				checks = append(checks, p.checkBotTeamMember(team))
+			}
+		}
+	}
+
+	if fix {
+		for _, check := range checks {
+			if check.Passed || check.fix == nil {
+				continue
+			}
+			if err := check.fix(); err != nil {
+				check.Problem = fmt.Sprintf("%s, and the automatic fix failed: %s", check.Problem, err.Error())
+				continue
+			}
+			check.Fixed = true
+		}
+	}
+
+	return checks, nil
+}
+
+// checkSiteURL makes sure the action buttons can reach the plugin through the site URL
+func (p *Plugin) checkSiteURL(config *model.Config) []*doctorCheck {
+	check := &doctorCheck{Name: "the site URL is set"}
+	if config == nil || config.ServiceSettings.SiteURL == nil || *config.ServiceSettings.SiteURL == "" {
+		check.Problem = "the site URL is empty, so the action buttons cannot reach the plugin"
+		check.Suggestion = "set **System Console > Environment > Web Server > Site URL**"
+		return []*doctorCheck{check}
+	}
+	check.Passed = true
+
+	siteURL, err := url.Parse(*config.ServiceSettings.SiteURL)
+	if err != nil {
+		return []*doctorCheck{{
+			Name:       "the site URL is valid",
+			Problem:    fmt.Sprintf("the site URL cannot be parsed: %s", err.Error()),
+			Suggestion: "fix **System Console > Environment > Web Server > Site URL**",
+		}}
+	}
+
+	host := siteURL.Hostname()
+	ip := net.ParseIP(host)
+	if host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return []*doctorCheck{check, {Name: "the site URL is not a local address", Passed: true}}
+	}
+
+	internalCheck := &doctorCheck{Name: fmt.Sprintf("the local site URL `%s` is an allowed untrusted internal connection", siteURL.String())}
+	if config.ServiceSettings.AllowedUntrustedInternalConnections != nil && isAllowedInternalHost(*config.ServiceSettings.AllowedUntrustedInternalConnections, host) {
+		internalCheck.Passed = true
+	} else {
+		internalCheck.Problem = fmt.Sprintf("`%s` is missing from the allowed untrusted internal connections, so the action buttons fail", host)
+		internalCheck.Suggestion = fmt.Sprintf("add `%s` to **System Console > Environment > Developer > Allow untrusted internal connections to**, or use a public site URL", host)
+	}
+
+	return []*doctorCheck{check, internalCheck}
+}
+
+// isAllowedInternalHost tells whether the host is matched by the hostnames, IPs or CIDR ranges of
+// the AllowedUntrustedInternalConnections setting.
+func isAllowedInternalHost(allowed, host string) bool {
+	ip := net.ParseIP(host)
+	for _, entry := range strings.FieldsFunc(allowed, func(r rune) bool { return r == ' ' || r == ',' }) {
+		if strings.EqualFold(entry, host) {
+			return true
+		}
+		if _, ipRange, err := net.ParseCIDR(entry); err == nil && ip != nil && ipRange.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkTownSquare makes sure the town-square channel, which the message templates rely on, exists
+func (p *Plugin) checkTownSquare(team *model.Team) *doctorCheck {
+	check := &doctorCheck{Name: fmt.Sprintf("team `%s` has a `town-square` channel", team.Name)}
+	if _, appErr := p.API.GetChannelByName(team.Id, "town-square", false); appErr != nil {
+		check.Problem = "the channel has not been found, so no welcome message can be sent for this team"
+		check.Suggestion = "restore the default channel of the team, or change its URL back to `town-square`"
+		return check
+	}
+	check.Passed = true
+
+	return check
+}
+
+// checkActionChannels makes sure the channels users are added to by the actions of a welcome
+// message exist and are not archived
+func (p *Plugin) checkActionChannels(team *model.Team, message *ConfigMessage) []*doctorCheck {
+	var checks []*doctorCheck
+	for _, action := range message.getActions() {
+		for _, channelName := range action.ChannelsAddedTo {
+			check := &doctorCheck{Name: fmt.Sprintf("channel `%s` of action `%s` of welcome message `%s` is available", channelName, action.ActionName, message.ID)}
+			channel, appErr := p.API.GetChannelByName(team.Id, channelName, true)
+			switch {
+			case appErr != nil:
+				check.Problem = fmt.Sprintf("the channel has not been found in team `%s`", team.Name)
+				check.Suggestion = "fix the channel name of the action, it must be the channel handle used in URLs"
+			case channel.DeleteAt > 0:
+				check.Problem = "the channel is archived, so users cannot be added to it"
+				check.Suggestion = "unarchive the channel, or remove it from the action"
+			default:
+				check.Passed = true
+			}
+			checks = append(checks, check)
+		}
+	}
+
+	return checks
+}
+
+// checkWelcomeChannel makes sure a channel with a welcome message still exists, and returns its team
+func (p *Plugin) checkWelcomeChannel(channelID string) (*doctorCheck, *model.Team) {
+	check := &doctorCheck{Name: fmt.Sprintf("channel `%s` with a welcome message is available", channelID)}
+	channel, appErr := p.API.GetChannel(channelID)
+	if appErr != nil {
+		check.Problem = "the channel has not been found"
+		check.Suggestion = "the welcome message is never sent, it can be removed with an import in replace mode"
+		return check, nil
+	}
+
+	check.Name = fmt.Sprintf("channel `%s` with a welcome message is available", channel.Name)
+	if channel.DeleteAt > 0 {
+		check.Problem = "the channel is archived, so nobody joins it anymore"
+		check.Suggestion = "unarchive the channel, or delete its welcome message"
+		return check, nil
+	}
+	if channel.Type == model.ChannelTypePrivate {
+		isMember, err := p.isBotChannelMember(channel.Id)
+		if err != nil {
+			check.Problem = fmt.Sprintf("the membership of @%s in the private channel cannot be checked: %s", botUsername, err.Error())
+			return check, nil
+		}
+		if !isMember {
+			check.Problem = fmt.Sprintf("the channel is private and @%s is not a member of it, so the welcome message is not sent", botUsername)
+			check.Suggestion = fmt.Sprintf("add @%s to the channel, or delete its welcome message", botUsername)
+			return check, nil
+		}
+	}
+	check.Passed = true
+
+	team, appErr := p.API.GetTeam(channel.TeamId)
+	if appErr != nil {
+		return check, nil
+	}
+
+	return check, team
+}
+
+// checkBotTeamMember makes sure the bot is a member of the team, which is required to send direct
+// messages when they are restricted to team members
+func (p *Plugin) checkBotTeamMember(team *model.Team) *doctorCheck {
+	check := &doctorCheck{Name: fmt.Sprintf("@%s is a member of team `%s`", botUsername, team.Name)}
+	if member, appErr := p.API.GetTeamMember(team.Id, p.botUserID); appErr == nil && member.DeleteAt == 0 {
+		check.Passed = true
+		return check
+	}
+
+	check.Problem = "direct messages are restricted to members of the same team, so the bot cannot message the members of this team"
+	check.Suggestion = fmt.Sprintf("add @%s to the team", botUsername)
+	check.fix = func() error {
+		if _, appErr := p.API.CreateTeamMember(team.Id, p.botUserID); appErr != nil {
+			return appErr
+		}
+		return nil
+	}
+
+	return check
+}
+
+func formatDoctorReport(checks []*doctorCheck, fix bool) string {
+	var str strings.Builder
+	str.WriteString("Welcomebot health check:\n")
+
+	failed, fixable := 0, 0
+	for _, check := range checks {
+		switch {
+		case check.Passed:
+			str.WriteString(fmt.Sprintf("\n:white_check_mark: %s", check.Name))
+			continue
+		case check.Fixed:
+			str.WriteString(fmt.Sprintf("\n:wrench: %s: %s. **Fixed.**", check.Name, check.Problem))
+			continue
+		}
+
+		failed++
+		str.WriteString(fmt.Sprintf("\n:x: %s: %s.", check.Name, check.Problem))
+		if check.Suggestion != "" {
+			str.WriteString(fmt.Sprintf(" To fix it, %s.", check.Suggestion))
+		}
+		if check.fix != nil && !fix {
+			fixable++
+		}
+	}
+
+	switch {
+	case failed == 0:
+		str.WriteString("\n\nNo problems found.")
+	case fixable > 0:
+		str.WriteString(fmt.Sprintf("\n\n%d problem(s) found. Run `/welcomebot doctor %s` to let the plugin fix %d of them.", failed, flagFix, fixable))
+	default:
+		str.WriteString(fmt.Sprintf("\n\n%d problem(s) found.", failed))
+	}
+
+	return str.String()
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	exportFormatJSON = "json"
+	exportFormatYAML = "yaml"
+
+	importModeMerge   = "merge"
+	importModeReplace = "replace"
+
+	flagMode = "--mode"
+
+	// Number of recent posts of the channel searched for the file to import
+	importPostsToSearch = 30
+)
+
+// ExportedConfiguration is the content of an export file
+type ExportedConfiguration struct {
+	// Team welcome messages managed in Mattermost
+	WelcomeMessages []*ConfigMessage
+
+	// Team welcome messages defined in config.json. They are exported to be copied to the
+	// config.json of another instance, and are never imported.
+	ConfigWelcomeMessages []*ConfigMessage `json:",omitempty"`
+
+	ChannelWelcomes []*ExportedChannelWelcome
+}
+
+// ExportedChannelWelcome is a channel welcome message in an export file. Channels are matched by
+// team and channel names on import, so that the file can be imported into another instance.
+type ExportedChannelWelcome struct {
+	TeamName               string
+	ChannelName            string
+	ChannelID              string
+	Message                string
+	AttachmentMessage      string                 `json:",omitempty"`
+	Actions                []*ConfigMessageAction `json:",omitempty"`
+	DeliveryMode           string                 `json:",omitempty"`
+	DelayInSeconds         int                    `json:",omitempty"`
+	RejoinPolicy           string                 `json:",omitempty"`
+	RejoinAfterDays        int                    `json:",omitempty"`
+	Translations           map[string]string      `json:",omitempty"`
+	AttachmentTranslations map[string]string      `json:",omitempty"`
+}
+
+func (w *ExportedChannelWelcome) record() *ChannelWelcomeRecord {
+	return &ChannelWelcomeRecord{
+		Message:           w.Message,
+		AttachmentMessage: w.AttachmentMessage,
+		Actions:           w.Actions,
+		DeliveryMode:      w.DeliveryMode,
+		DelayInSeconds:    w.DelayInSeconds,
+		RejoinPolicy:      w.RejoinPolicy,
+		RejoinAfterDays:   w.RejoinAfterDays,
+	}
+}
+
+// importSummary describes the changes made, or that would be made, by an import
+type importSummary struct {
+	TeamWelcomesCreated    int
+	TeamWelcomesUpdated    int
+	TeamWelcomesDeleted    int
+	ChannelWelcomesSet     int
+	ChannelWelcomesDeleted int
+	Warnings               []string
+}
+
+func (p *Plugin) exportConfiguration() (*ExportedConfiguration, error) {
+	exported := &ExportedConfiguration{}
+	for _, message := range p.getWelcomeMessages() {
+		if message.Source == messageSourceConfig {
+			exported.ConfigWelcomeMessages = append(exported.ConfigWelcomeMessages, message)
+		} else {
+			exported.WelcomeMessages = append(exported.WelcomeMessages, message)
+		}
+	}
+
+	welcomes, err := p.listChannelWelcomes()
+	if err != nil {
+		return nil, err
+	}
+
+	for channelID, record := range welcomes {
+		translations, err := p.getChannelWelcomeTranslations(channelID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get the translations of the welcome message of channel %s", channelID)
+		}
+
+		messages, attachmentMessages := splitChannelWelcomeTranslations(translations)
+		welcome := &ExportedChannelWelcome{
+			ChannelID:              channelID,
+			Message:                record.Message,
+			AttachmentMessage:      record.AttachmentMessage,
+			Actions:                record.Actions,
+			DeliveryMode:           record.DeliveryMode,
+			DelayInSeconds:         record.DelayInSeconds,
+			RejoinPolicy:           record.RejoinPolicy,
+			RejoinAfterDays:        record.RejoinAfterDays,
+			Translations:           messages,
+			AttachmentTranslations: attachmentMessages,
+		}
+		if channel, appErr := p.API.GetChannel(channelID); appErr == nil {
+			welcome.ChannelName = channel.Name
+			if team, appErr := p.API.GetTeam(channel.TeamId); appErr == nil {
+				welcome.TeamName = team.Name
+			}
+		}
+		exported.ChannelWelcomes = append(exported.ChannelWelcomes, welcome)
+	}
+
+	return exported, nil
+}
+
+// encodeExport serializes the exported configuration. YAML is produced from the JSON encoding, so
+// that both formats use the same field names as config.json.
+func encodeExport(exported *ExportedConfiguration, format string) ([]byte, error) {
+	data, err := json.MarshalIndent(exported, "", "  ")
+	if err != nil || format == exportFormatJSON {
+		return data, err
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+
+	return yaml.Marshal(value)
+}
+
+func decodeExport(data []byte, format string) (*ExportedConfiguration, error) {
+	if format == exportFormatYAML {
+		var value interface{}
+		if err := yaml.Unmarshal(data, &value); err != nil {
+			return nil, err
+		}
+
+		var err error
+		if data, err = json.Marshal(convertYAMLValue(value)); err != nil {
+			return nil, err
+		}
+	}
+
+	var exported ExportedConfiguration
+	if err := json.Unmarshal(data, &exported); err != nil {
+		return nil, err
+	}
+
+	return &exported, nil
+}
+
+// convertYAMLValue turns the maps decoded by the YAML library into maps which can be encoded to JSON.
+func convertYAMLValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			converted[fmt.Sprint(key)] = convertYAMLValue(item)
+		}
+		return converted
+	case []interface{}:
+		for i, item := range v {
+			v[i] = convertYAMLValue(item)
+		}
+		return v
+	default:
+		return value
+	}
+}
+
+// postExport sends the exported configuration as a file in the direct channel with the user.
+func (p *Plugin) postExport(userID, format string) error {
+	exported, err := p.exportConfiguration()
+	if err != nil {
+		return err
+	}
+
+	data, err := encodeExport(exported, format)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode the configuration")
+	}
+
+	channel, appErr := p.API.GetDirectChannel(userID, p.botUserID)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to get the direct channel")
+	}
+
+	filename := fmt.Sprintf("welcomebot-%s.%s", time.Now().UTC().Format("20060102-150405"), format)
+	fileInfo, appErr := p.API.UploadFile(data, channel.Id, filename)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to upload the file")
+	}
+
+	post := &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: channel.Id,
+		Message:   fmt.Sprintf("Exported %d team welcome messages and %d channel welcome messages.", len(exported.WelcomeMessages)+len(exported.ConfigWelcomeMessages), len(exported.ChannelWelcomes)),
+		FileIds:   []string{fileInfo.Id},
+	}
+	if _, appErr := p.API.CreatePost(post); appErr != nil {
+		return errors.Wrap(appErr, "failed to post the file")
+	}
+
+	return nil
+}
+
+// findImportFile loads the most recent file posted by the user in the channel.
+func (p *Plugin) findImportFile(userID, channelID string) (*model.FileInfo, []byte, error) {
+	posts, appErr := p.API.GetPostsForChannel(channelID, 0, importPostsToSearch)
+	if appErr != nil {
+		return nil, nil, errors.Wrap(appErr, "failed to get the posts of the channel")
+	}
+
+	for _, postID := range posts.Order {
+		post := posts.Posts[postID]
+		if post.UserId != userID || len(post.FileIds) == 0 {
+			continue
+		}
+
+		fileInfo, appErr := p.API.GetFileInfo(post.FileIds[0])
+		if appErr != nil {
+			return nil, nil, errors.Wrap(appErr, "failed to get the file info")
+		}
+
+		data, appErr := p.API.GetFile(fileInfo.Id)
+		if appErr != nil {
+			return nil, nil, errors.Wrap(appErr, "failed to get the file")
+		}
+
+		return fileInfo, data, nil
+	}
+
+	return nil, nil, errors.New("no file found, please upload the file to import in this channel first")
+}
+
+// importConfiguration stores the team and channel welcome messages of an export. In merge mode,
+// existing messages are kept unless the file redefines them. In replace mode, the messages managed
+// in Mattermost which are missing from the file are deleted. Messages defined in config.json are
+// never changed.
+func (p *Plugin) importConfiguration(exported *ExportedConfiguration, userID, mode string, dryRun bool) (*importSummary, error) {
+	if err := checkImportedMessageIDs(exported.WelcomeMessages); err != nil {
+		return nil, err
+	}
+
+	summary := &importSummary{}
+	if len(exported.ConfigWelcomeMessages) > 0 {
+		summary.Warnings = append(summary.Warnings, fmt.Sprintf("%d team welcome message(s) defined in config.json have been skipped, copy them to the config.json of this instance if needed", len(exported.ConfigWelcomeMessages)))
+	}
+
+	stored, err := p.getStoredWelcomeMessages()
+	if err != nil {
+		return nil, err
+	}
+	storedByID := make(map[string]*ConfigMessage, len(stored))
+	for _, message := range stored {
+		storedByID[message.ID] = message
+	}
+
+	// The action names of each message must not be used by the other messages of its team which
+	// are kept: the ones of config.json, the ones imported before it and, in merge mode, the stored
+	// ones the file doesn't successfully redefine
+	fileIDs := make(map[string]bool, len(exported.WelcomeMessages))
+	for _, message := range exported.WelcomeMessages {
+		fileIDs[message.ID] = true
+	}
+	kept := append([]*ConfigMessage{}, p.getSnapshot().configMessages...)
+	if mode == importModeMerge {
+		for _, message := range stored {
+			if !fileIDs[message.ID] {
+				kept = append(kept, message)
+			}
+		}
+	}
+
+	var messages []*ConfigMessage
+	importedIDs := make(map[string]bool)
+	for _, message := range exported.WelcomeMessages {
+		if message.ID == "" {
+			message.ID = model.NewId()
+		}
+		if existing := p.getWelcomeMessageByID(message.ID); existing != nil && existing.Source == messageSourceConfig {
+			summary.Warnings = append(summary.Warnings, fmt.Sprintf("team welcome message `%s` is defined in config.json and has been skipped", message.ID))
+			continue
+		}
+		problems := p.checkWelcomeMessage(message)
+		problems = append(problems, checkActionNamesInTeam(message, kept)...)
+		if len(problems) > 0 {
+			summary.Warnings = append(summary.Warnings, fmt.Sprintf("team welcome message `%s` is invalid and has been skipped: %s", message.ID, strings.Join(problems, "; ")))
+			if mode == importModeMerge && storedByID[message.ID] != nil {
+				kept = append(kept, storedByID[message.ID])
+			}
+			continue
+		}
+
+		if storedByID[message.ID] != nil {
+			summary.TeamWelcomesUpdated++
+		} else {
+			summary.TeamWelcomesCreated++
+		}
+		importedIDs[message.ID] = true
+		messages = append(messages, message)
+		kept = append(kept, message)
+	}
+
+	if mode == importModeMerge {
+		for _, message := range stored {
+			if !importedIDs[message.ID] {
+				messages = append(messages, message)
+			}
+		}
+	} else {
+		summary.TeamWelcomesDeleted = len(stored) - summary.TeamWelcomesUpdated
+	}
+
+	channelWelcomes := make(map[string]*ExportedChannelWelcome)
+	for _, welcome := range exported.ChannelWelcomes {
+		channelID := welcome.ChannelID
+		if welcome.TeamName != "" && welcome.ChannelName != "" {
+			if channel, appErr := p.API.GetChannelByNameForTeamName(welcome.TeamName, welcome.ChannelName, false); appErr == nil {
+				channelID = channel.Id
+			}
+		}
+		channel, appErr := p.API.GetChannel(channelID)
+		if appErr != nil {
+			summary.Warnings = append(summary.Warnings, fmt.Sprintf("channel `%s` of team `%s` has not been found and has been skipped", welcome.ChannelName, welcome.TeamName))
+			continue
+		}
+		if err := p.checkChannelWelcomeSupported(channel); err != nil {
+			summary.Warnings = append(summary.Warnings, fmt.Sprintf("channel `%s` of team `%s` has been skipped: %s", welcome.ChannelName, welcome.TeamName, err))
+			continue
+		}
+		if err := p.checkChannelWelcome(channel, welcome.record(), newChannelWelcomeTranslations(welcome.Translations, welcome.AttachmentTranslations)); err != nil {
+			summary.Warnings = append(summary.Warnings, fmt.Sprintf("the welcome message of channel `%s` of team `%s` is invalid and has been skipped: %s", welcome.ChannelName, welcome.TeamName, err))
+			continue
+		}
+		if err := p.checkChannelWelcomeActionsAllowed(userID, channel, welcome.record()); err != nil {
+			summary.Warnings = append(summary.Warnings, fmt.Sprintf("the welcome message of channel `%s` of team `%s` has been skipped: %s", welcome.ChannelName, welcome.TeamName, err))
+			continue
+		}
+
+		channelWelcomes[channelID] = welcome
+	}
+	summary.ChannelWelcomesSet = len(channelWelcomes)
+
+	existingChannelWelcomes, err := p.listChannelWelcomes()
+	if err != nil {
+		return nil, err
+	}
+	var deletedChannelIDs []string
+	if mode == importModeReplace {
+		for channelID := range existingChannelWelcomes {
+			if _, ok := channelWelcomes[channelID]; !ok {
+				deletedChannelIDs = append(deletedChannelIDs, channelID)
+			}
+		}
+	}
+	summary.ChannelWelcomesDeleted = len(deletedChannelIDs)
+
+	if dryRun {
+		return summary, nil
+	}
+
+	if err := p.replaceStoredWelcomeMessages(messages); err != nil {
+		return nil, err
+	}
+	for channelID, welcome := range channelWelcomes {
+		record := welcome.record()
+		record.SetBy = userID
+		if err := p.setChannelWelcome(channelID, record); err != nil {
+			return nil, errors.Wrapf(err, "failed to set the welcome message of channel %s", channelID)
+		}
+		if err := p.setChannelWelcomeTranslations(channelID, newChannelWelcomeTranslations(welcome.Translations, welcome.AttachmentTranslations)); err != nil {
+			return nil, errors.Wrapf(err, "failed to set the translations of the welcome message of channel %s", channelID)
+		}
+	}
+	for _, channelID := range deletedChannelIDs {
+		if err := p.deleteChannelWelcome(channelID); err != nil {
+			return nil, errors.Wrapf(err, "failed to delete the welcome message of channel %s", channelID)
+		}
+	}
+
+	return summary, nil
+}
+
+// checkImportedMessageIDs makes sure the team welcome messages of a file don't share an ID, which
+// would store both of them under the same ID.
+func checkImportedMessageIDs(messages []*ConfigMessage) error {
+	ids := make(map[string]bool, len(messages))
+	for _, message := range messages {
+		if message == nil {
+			return errors.New("the file contains an empty team welcome message")
+		}
+		if message.ID == "" {
+			continue
+		}
+		if ids[message.ID] {
+			return errors.Errorf("team welcome message `%s` is defined several times in the file", message.ID)
+		}
+		ids[message.ID] = true
+	}
+
+	return nil
+}
+
+// getImportFormat guesses the format of a file from its extension.
+func getImportFormat(fileInfo *model.FileInfo) string {
+	switch strings.ToLower(filepath.Ext(fileInfo.Name)) {
+	case ".yaml", ".yml":
+		return exportFormatYAML
+	default:
+		return exportFormatJSON
+	}
+}
+
+func (s *importSummary) String() string {
+	var str strings.Builder
+	str.WriteString(fmt.Sprintf(" * team welcome messages: %d created, %d updated, %d deleted\n", s.TeamWelcomesCreated, s.TeamWelcomesUpdated, s.TeamWelcomesDeleted))
+	str.WriteString(fmt.Sprintf(" * channel welcome messages: %d set, %d deleted", s.ChannelWelcomesSet, s.ChannelWelcomesDeleted))
+	if len(s.Warnings) > 0 {
+		str.WriteString("\n\nWarnings:")
+		for _, warning := range s.Warnings {
+			str.WriteString("\n * " + warning)
+		}
+	}
+
+	return str.String()
+}
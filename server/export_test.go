@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestCheckImportedMessageIDs(t *testing.T) {
+	for name, tc := range map[string]struct {
+		messages []*ConfigMessage
+		valid    bool
+	}{
+		"none":          {valid: true},
+		"distinct IDs":  {messages: []*ConfigMessage{{ID: "a"}, {ID: "b"}}, valid: true},
+		"without IDs":   {messages: []*ConfigMessage{{}, {}}, valid: true},
+		"duplicate IDs": {messages: []*ConfigMessage{{ID: "a"}, {ID: "b"}, {ID: "a"}}},
+		"empty message": {messages: []*ConfigMessage{{ID: "a"}, nil}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := checkImportedMessageIDs(tc.messages); (err == nil) != tc.valid {
+				t.Errorf("expected valid %v, got error %v", tc.valid, err)
+			}
+		})
+	}
+}
+
+func TestImportConfiguration(t *testing.T) {
+	button := func(name string) []*ConfigMessageAction {
+		return []*ConfigMessageAction{{ActionType: actionTypeButton, ActionName: name, ActionDisplayName: name}}
+	}
+	config := &ConfigMessage{ID: "config", TeamName: "team", Message: []string{"Hi"}, Actions: button("join"), Source: messageSourceConfig}
+	storedA := &ConfigMessage{ID: "a", TeamName: "team", Message: []string{"A"}, Actions: button("a")}
+	storedB := &ConfigMessage{ID: "b", TeamName: "team", Message: []string{"B"}}
+
+	for name, tc := range map[string]struct {
+		mode          string
+		dryRun        bool
+		messages      []*ConfigMessage
+		expected      *importSummary
+		expectedIDs   []string
+		expectedError bool
+		warning       string
+	}{
+		"merge": {
+			mode:        importModeMerge,
+			messages:    []*ConfigMessage{{ID: "a", TeamName: "team", Message: []string{"New A"}}, {ID: "c", TeamName: "team", Message: []string{"C"}}},
+			expected:    &importSummary{TeamWelcomesCreated: 1, TeamWelcomesUpdated: 1},
+			expectedIDs: []string{"a", "b", "c"},
+		},
+		"replace": {
+			mode:        importModeReplace,
+			messages:    []*ConfigMessage{{ID: "a", TeamName: "team", Message: []string{"New A"}}},
+			expected:    &importSummary{TeamWelcomesUpdated: 1, TeamWelcomesDeleted: 1},
+			expectedIDs: []string{"a"},
+		},
+		"dry run": {
+			mode:        importModeReplace,
+			dryRun:      true,
+			messages:    []*ConfigMessage{{ID: "c", TeamName: "team", Message: []string{"C"}}},
+			expected:    &importSummary{TeamWelcomesCreated: 1, TeamWelcomesDeleted: 2},
+			expectedIDs: []string{"a", "b"},
+		},
+		"message of config.json": {
+			mode:        importModeMerge,
+			messages:    []*ConfigMessage{{ID: "config", TeamName: "team", Message: []string{"Hi"}}},
+			expected:    &importSummary{},
+			expectedIDs: []string{"a", "b"},
+			warning:     "is defined in config.json",
+		},
+		"unknown team": {
+			mode:        importModeMerge,
+			messages:    []*ConfigMessage{{ID: "c", TeamName: "other", Message: []string{"C"}}},
+			expected:    &importSummary{},
+			expectedIDs: []string{"a", "b"},
+			warning:     "team `other` has not been found",
+		},
+		"action name of config.json": {
+			mode:        importModeReplace,
+			messages:    []*ConfigMessage{{ID: "c", TeamName: "team", Message: []string{"C"}, Actions: button("join")}},
+			expected:    &importSummary{TeamWelcomesDeleted: 2},
+			expectedIDs: []string{},
+			warning:     "action `join` is also defined by welcome message `config`",
+		},
+		"action name of a kept message": {
+			mode:        importModeMerge,
+			messages:    []*ConfigMessage{{ID: "c", TeamName: "team", Message: []string{"C"}, Actions: button("a")}},
+			expected:    &importSummary{},
+			expectedIDs: []string{"a", "b"},
+			warning:     "action `a` is also defined by welcome message `a`",
+		},
+		"action name of a replaced message": {
+			mode:        importModeReplace,
+			messages:    []*ConfigMessage{{ID: "c", TeamName: "team", Message: []string{"C"}, Actions: button("a")}},
+			expected:    &importSummary{TeamWelcomesCreated: 1, TeamWelcomesDeleted: 2},
+			expectedIDs: []string{"c"},
+		},
+		"action name used twice in the file": {
+			mode: importModeReplace,
+			messages: []*ConfigMessage{
+				{ID: "c", TeamName: "team", Message: []string{"C"}, Actions: button("help")},
+				{ID: "d", TeamName: "team", Message: []string{"D"}, Actions: button("help")},
+			},
+			expected:    &importSummary{TeamWelcomesCreated: 1, TeamWelcomesDeleted: 2},
+			expectedIDs: []string{"c"},
+			warning:     "action `help` is also defined by welcome message `c`",
+		},
+		"duplicate IDs": {
+			mode:          importModeMerge,
+			messages:      []*ConfigMessage{{ID: "c", TeamName: "team"}, {ID: "c", TeamName: "team"}},
+			expectedError: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			api := &fakeAPI{teams: map[string]*model.Team{"team": {Id: "team-id", Name: "team"}}}
+			p := newTestPlugin(api)
+			data, err := json.Marshal([]*ConfigMessage{storedA, storedB})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			api.kv[welcomebotTeamWelcomesKey] = data
+			p.snapshot.Store(p.newConfigurationSnapshot([]*ConfigMessage{config}, nil))
+			p.reloadStoredWelcomeMessages(false)
+
+			summary, err := p.importConfiguration(&ExportedConfiguration{WelcomeMessages: tc.messages}, "user-id", tc.mode, tc.dryRun)
+			if tc.expectedError {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", summary)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if (tc.warning == "" && len(summary.Warnings) > 0) || (tc.warning != "" && (len(summary.Warnings) != 1 || !strings.Contains(summary.Warnings[0], tc.warning))) {
+				t.Errorf("expected warning %q, got %q", tc.warning, summary.Warnings)
+			}
+			summary.Warnings = nil
+			if !reflect.DeepEqual(summary, tc.expected) {
+				t.Errorf("expected %+v, got %+v", tc.expected, summary)
+			}
+
+			stored, err := p.getStoredWelcomeMessages()
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			ids := make([]string, 0, len(stored))
+			for _, message := range stored {
+				ids = append(ids, message.ID)
+			}
+			sort.Strings(ids)
+			if strings.Join(ids, ",") != strings.Join(tc.expectedIDs, ",") {
+				t.Errorf("expected stored messages %v, got %v", tc.expectedIDs, ids)
+			}
+		})
+	}
+}
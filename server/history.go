@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	welcomebotHistoryKey = "history_"
+
+	// Only the latest events of each user are kept
+	maxHistoryEvents = 200
+
+	historyEventWelcomeSent   = "welcome_sent"
+	historyEventActionTaken   = "action_taken"
+	historyEventChannelJoined = "channel_joined"
+)
+
+// HistoryEvent is an entry of the welcome timeline of a user
+type HistoryEvent struct {
+	Type     string `json:"type"`
+	CreateAt int64  `json:"create_at"`
+	TeamID   string `json:"team_id,omitempty"`
+
+	// Set for welcome_sent events
+	MessageID string `json:"message_id,omitempty"`
+	PostID    string `json:"post_id,omitempty"`
+
+	// Set for action_taken and channel_joined events
+	ActionName string `json:"action_name,omitempty"`
+
+	// Set for channel_joined events
+	ChannelID   string `json:"channel_id,omitempty"`
+	ChannelName string `json:"channel_name,omitempty"`
+}
+
+func getHistoryKey(userID string) string {
+	return welcomebotHistoryKey + userID
+}
+
+// recordHistoryEvent appends an event to the timeline of the user. Errors are logged rather than
+// returned, as the welcome goes on without the event.
+func (p *Plugin) recordHistoryEvent(userID string, event *HistoryEvent) {
+	event.CreateAt = model.GetMillis()
+
+	err := p.client.KV.SetAtomicWithRetries(getHistoryKey(userID), func(oldValue []byte) (interface{}, error) {
+		var events []*HistoryEvent
+		if len(oldValue) > 0 {
+			if err := json.Unmarshal(oldValue, &events); err != nil {
+				return nil, err
+			}
+		}
+
+		events = append(events, event)
+		if len(events) > maxHistoryEvents {
+			events = events[len(events)-maxHistoryEvents:]
+		}
+		return events, nil
+	})
+	if err != nil {
+		p.API.LogError("failed to record history event", "user_id", userID, "type", event.Type, "err", err.Error())
+	}
+}
+
+// getHistory returns the timeline of the user, oldest event first.
+func (p *Plugin) getHistory(userID string) ([]*HistoryEvent, error) {
+	var events []*HistoryEvent
+	if err := p.client.KV.Get(getHistoryKey(userID), &events); err != nil {
+		return nil, errors.Wrap(err, "failed to get history")
+	}
+
+	return events, nil
+}
+
+// formatHistory renders the timeline of a user as a Markdown table.
+func (p *Plugin) formatHistory(user *model.User, events []*HistoryEvent) string {
+	if len(events) == 0 {
+		return fmt.Sprintf("No welcome history for @%s", user.Username)
+	}
+
+	teamNames := make(map[string]string)
+	getTeamName := func(teamID string) string {
+		if _, ok := teamNames[teamID]; !ok {
+			teamNames[teamID] = teamID
+			if team, appErr := p.API.GetTeam(teamID); appErr == nil {
+				teamNames[teamID] = team.Name
+			}
+		}
+		return teamNames[teamID]
+	}
+
+	var str strings.Builder
+	str.WriteString(fmt.Sprintf("Welcome history for @%s:\n\n", user.Username))
+	str.WriteString("| Time (UTC) | Team | Event | Details |\n|---|---|---|---|\n")
+	for _, event := range events {
+		var details string
+		switch event.Type {
+		case historyEventWelcomeSent:
+			details = fmt.Sprintf("message `%s`, [post](%s/_redirect/pl/%s)", event.MessageID, p.getSiteURL(), event.PostID)
+		case historyEventActionTaken:
+			details = fmt.Sprintf("action `%s`", event.ActionName)
+		case historyEventChannelJoined:
+			details = fmt.Sprintf("~%s (action `%s`)", event.ChannelName, event.ActionName)
+		}
+
+		str.WriteString(fmt.Sprintf("| %s | %s | %s | %s |\n",
+			time.UnixMilli(event.CreateAt).UTC().Format("2006-01-02 15:04:05"),
+			getTeamName(event.TeamID),
+			event.Type,
+			details,
+		))
+	}
+
+	return str.String()
+}
@@ -1,9 +1,6 @@
 package main
 
 import (
-	"fmt"
-	"time"
-
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
 	"github.com/mattermost/mattermost/server/public/shared/mlog"
@@ -16,79 +13,108 @@ func (p *Plugin) UserHasJoinedTeam(c *plugin.Context, teamMember *model.TeamMemb
 	if data == nil {
 		return
 	}
-
-	for _, message := range p.getWelcomeMessages() {
-		if data.User.IsGuest() && !message.IncludeGuests {
-			continue
-		}
-
-		if message.TeamName == data.Team.Name {
-			go p.processWelcomeMessage(*data, *message)
-		}
+	if actor != nil {
+		data.setActor(actor.Id)
 	}
+
+	p.startWelcomeFlow(data, welcomeOptions{})
 }
 
 // UserHasJoinedChannel is invoked after the membership has been committed to
 // the database. If actor is not nil, the user was invited to the channel by
 // the actor.
-func (p *Plugin) UserHasJoinedChannel(c *plugin.Context, channelMember *model.ChannelMember, _ *model.User) {
-	if channelInfo, appErr := p.API.GetChannel(channelMember.ChannelId); appErr != nil {
+func (p *Plugin) UserHasJoinedChannel(c *plugin.Context, channelMember *model.ChannelMember, actor *model.User) {
+	channelInfo, appErr := p.API.GetChannel(channelMember.ChannelId)
+	if appErr != nil {
 		mlog.Error(
 			"error occurred while checking the type of the chanel",
 			mlog.String("channelId", channelMember.ChannelId),
 			mlog.Err(appErr),
 		)
 		return
-	} else if channelInfo.Type == model.ChannelTypePrivate {
+	} else if channelInfo.Type == model.ChannelTypeDirect || channelInfo.Type == model.ChannelTypeGroup {
 		return
 	}
 
-	key := fmt.Sprintf("%s%s", welcomebotChannelWelcomeKey, channelMember.ChannelId)
-	data, appErr := p.API.KVGet(key)
-	if appErr != nil {
+	// Most channels have no welcome message, so the user is only loaded for the ones which have one
+	welcome, err := p.getChannelWelcome(channelInfo.Id)
+	if err != nil {
 		mlog.Error(
 			"error occurred while retrieving the welcome message",
 			mlog.String("channelId", channelMember.ChannelId),
-			mlog.Err(appErr),
+			mlog.Err(err),
 		)
 		return
 	}
 
-	if data == nil {
+	if welcome == nil {
 		// No welcome message for the given channel
 		return
 	}
 
-	dmChannel, err := p.API.GetDirectChannel(channelMember.UserId, p.botUserID)
-	if err != nil {
+	if channelInfo.Type == model.ChannelTypePrivate {
+		isMember, err := p.isBotChannelMember(channelInfo.Id)
+		if err != nil {
+			mlog.Error(
+				"error occurred while checking the membership of the bot",
+				mlog.String("channelId", channelMember.ChannelId),
+				mlog.Err(err),
+			)
+			return
+		}
+		if !isMember {
+			// Removing the bot from a private channel opts the channel out of its welcome message
+			return
+		}
+	}
+
+	user, appErr := p.API.GetUser(channelMember.UserId)
+	if appErr != nil {
 		mlog.Error(
-			"error occurred while creating direct channel to the user",
+			"error occurred while retrieving the user",
 			mlog.String("UserId", channelMember.UserId),
+			mlog.Err(appErr),
+		)
+		return
+	}
+
+	welcome, err = p.localizeChannelWelcome(channelMember.ChannelId, welcome, user)
+	if err != nil {
+		mlog.Error(
+			"error occurred while retrieving the translations of the welcome message",
+			mlog.String("channelId", channelMember.ChannelId),
 			mlog.Err(err),
 		)
 		return
 	}
 
-	// We send a DM and an opportunistic ephemeral message to the channel. See
-	// the discussion at the link below for more details:
-	// https://github.com/mattermost/mattermost-plugin-welcomebot/pull/31#issuecomment-611691023
-	postDM := &model.Post{
-		UserId:    p.botUserID,
-		ChannelId: dmChannel.Id,
-		Message:   string(data),
+	var actorID string
+	if actor != nil {
+		actorID = actor.Id
 	}
-	if _, appErr := p.API.CreatePost(postDM); appErr != nil {
-		mlog.Error("failed to post welcome message to the channel",
-			mlog.String("channelId", dmChannel.Id),
-			mlog.Err(appErr),
-		)
+
+	joinedAt, err := p.startChannelDeliveryRound(user.Id, channelInfo.Id, welcome)
+	if err != nil {
+		if err != errDuplicateJoin && err != errRejoinNotAllowed {
+			mlog.Error(
+				"error occurred while recording the join of the channel",
+				mlog.String("channelId", channelMember.ChannelId),
+				mlog.Err(err),
+			)
+		}
+		return
 	}
 
-	postChannel := &model.Post{
-		UserId:    p.botUserID,
-		ChannelId: channelMember.ChannelId,
-		Message:   string(data),
+	if welcome.DelayInSeconds > 0 {
+		if err := p.scheduleChannelWelcome(channelInfo, user.Id, actorID, joinedAt, welcome.DelayInSeconds); err != nil {
+			mlog.Error(
+				"error occurred while scheduling the welcome message",
+				mlog.String("channelId", channelMember.ChannelId),
+				mlog.Err(err),
+			)
+		}
+		return
 	}
-	time.Sleep(1 * time.Second)
-	_ = p.API.SendEphemeralPost(channelMember.UserId, postChannel)
+
+	p.deliverChannelWelcome(channelInfo, user, actorID, welcome, true)
 }
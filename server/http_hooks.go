@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"net/http"
+	"strings"
 
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
@@ -11,6 +12,27 @@ import (
 // ServeHTTP allows the plugin to implement the http.Handler interface. Requests destined for the
 // /plugins/{id} path will be routed to the plugin.
 func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/addchannels":
+		p.handleAddChannels(w, r)
+	case "/channelaction":
+		p.handleChannelAction(w, r)
+	case "/history":
+		p.handleHistory(w, r)
+	case "/metrics":
+		p.handleMetrics(w, r)
+	case "/dialog/team_welcome":
+		p.handleTeamWelcomeDialog(w, r)
+	default:
+		if strings.HasPrefix(r.URL.Path, apiPathPrefix) {
+			p.serveAPI(w, r)
+			return
+		}
+		http.NotFound(w, r)
+	}
+}
+
+func (p *Plugin) handleAddChannels(w http.ResponseWriter, r *http.Request) {
 	var action *Action
 	if err := json.NewDecoder(r.Body).Decode(&action); err != nil || action == nil {
 		p.API.LogDebug("failed to decode action from request body", "error", err.Error())
@@ -25,7 +47,7 @@ func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	data := &MessageTemplate{}
+	data := &MessageTemplate{lazy: p.newLazyTemplateData()}
 	var err *model.AppError
 
 	if data.User, err = p.API.GetUser(action.Context.UserID); err != nil {
@@ -55,23 +77,125 @@ func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	switch r.URL.Path {
-	case "/addchannels":
-		for _, wm := range p.getWelcomeMessages() {
-			if data.Team.Name == wm.TeamName {
-				for _, ac := range wm.Actions {
-					if ac.ActionName == action.Context.Action {
-						p.processActionMessage(*data, action, *ac)
-						p.encodeEphemeralMessage(w, "")
-						return
-					}
-				}
-			}
+	ac := p.getTeamWelcomeAction(data.Team.Name, action.Context.Action)
+	if action.Context.Variant != "" {
+		if variantAction := p.getVariantAction(data.Team.Name, action.Context.MessageID, action.Context.Variant, action.Context.Action); variantAction != nil {
+			ac = variantAction
+			p.recordVariantClick(data.User.Id, data.Team.Id, action.Context.MessageID, action.Context.Variant, action.Context.Action)
 		}
+	}
+
+	if ac != nil {
+		p.processActionMessage(*data, action, *ac.localized(p.getLocaleFallbacks(data.User)))
+		p.encodeEphemeralMessage(w, "")
+		return
+	}
+
+	p.encodeEphemeralMessage(w, "WelcomeBot Error: The action wasn't found for "+action.Context.Action)
+}
+
+// handleChannelAction handles the clicks on the buttons of the channel welcome messages. The
+// action is looked up in the current welcome message of the channel.
+func (p *Plugin) handleChannelAction(w http.ResponseWriter, r *http.Request) {
+	var action *Action
+	if err := json.NewDecoder(r.Body).Decode(&action); err != nil || action == nil || action.Context == nil {
+		p.API.LogDebug("failed to decode action from request body")
+		p.encodeEphemeralMessage(w, "WelcomeBot Error: We could not decode the action")
+		return
+	}
+
+	mattermostUserID := r.Header.Get("Mattermost-User-Id")
+	if mattermostUserID == "" || mattermostUserID != action.Context.UserID {
+		p.API.LogError("http request not authenticated: no Mattermost-User-Id")
+		http.Error(w, "not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	user, appErr := p.API.GetUser(action.Context.UserID)
+	if appErr != nil {
+		p.API.LogError("failed to query user", "user_id", action.Context.UserID, "error", appErr.Error())
+		p.encodeEphemeralMessage(w, "WelcomeBot Error: We could not find the supplied user")
+		return
+	}
+
+	channel, appErr := p.API.GetChannel(action.Context.ChannelID)
+	if appErr != nil {
+		p.API.LogError("failed to query channel", "channel_id", action.Context.ChannelID, "error", appErr.Error())
+		p.encodeEphemeralMessage(w, "WelcomeBot Error: We could not find the supplied channel")
+		return
+	}
 
+	// Check to make sure you're still in the channel
+	if _, appErr := p.API.GetChannelMember(channel.Id, user.Id); appErr != nil {
+		p.API.LogError("Didn't have access to channel", "user_id", user.Id, "channel_id", channel.Id, "error", appErr.Error())
+		p.encodeEphemeralMessage(w, "WelcomeBot Error: You do not appear to have access to this channel")
+		return
+	}
+
+	welcome, err := p.getLocalizedChannelWelcome(channel.Id, user)
+	if err != nil {
+		p.API.LogError("failed to get channel welcome message", "channel_id", channel.Id, "error", err.Error())
+		p.encodeEphemeralMessage(w, "WelcomeBot Error: We could not find the welcome message of the channel")
+		return
+	}
+
+	var ac *ConfigMessageAction
+	if welcome != nil {
+		ac = welcome.getAction(action.Context.Action)
+	}
+	if ac == nil {
 		p.encodeEphemeralMessage(w, "WelcomeBot Error: The action wasn't found for "+action.Context.Action)
-	default:
-		http.NotFound(w, r)
+		return
+	}
+
+	data := p.newChannelMessageTemplate(channel, user, "")
+	p.encodeEphemeralMessage(w, p.processChannelAction(data, welcome, ac))
+}
+
+// handleHistory returns the welcome timeline of the user given by the user_id query parameter.
+// Only system admins are allowed to read it.
+func (p *Plugin) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	mattermostUserID := r.Header.Get("Mattermost-User-Id")
+	if mattermostUserID == "" {
+		http.Error(w, "not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	isSysadmin, err := p.hasSysadminRole(mattermostUserID)
+	if err != nil {
+		p.API.LogError("failed to check permissions", "user_id", mattermostUserID, "error", err.Error())
+		http.Error(w, "failed to check permissions", http.StatusInternalServerError)
+		return
+	}
+	if !isSysadmin {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	if !model.IsValidId(userID) {
+		http.Error(w, "invalid user_id", http.StatusBadRequest)
+		return
+	}
+
+	events, err := p.getHistory(userID)
+	if err != nil {
+		p.API.LogError("failed to get history", "user_id", userID, "error", err.Error())
+		http.Error(w, "failed to get history", http.StatusInternalServerError)
+		return
+	}
+	if events == nil {
+		events = []*HistoryEvent{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(events); err != nil {
+		p.API.LogWarn("failed to write history")
 	}
 }
 
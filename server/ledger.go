@@ -0,0 +1,271 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	welcomebotLedgerKey = "ledger_"
+
+	// Ledger of the channel welcome messages
+	welcomebotChannelLedgerKey = "chanledger_"
+
+	// Joins of the same user to the same team within this window are handled as a single join,
+	// e.g. when the hook is retried or fired by several nodes.
+	duplicateJoinWindow = time.Minute
+)
+
+var (
+	errDuplicateJoin    = errors.New("the join was already handled")
+	errRejoinNotAllowed = errors.New("the rejoin policy does not allow sending the message again")
+	errAlreadyDelivered = errors.New("the step was already delivered")
+	errStaleDelivery    = errors.New("the delivery was superseded by a later join")
+)
+
+// DeliveryRecord is the ledger entry of a welcome message for a user of a team
+type DeliveryRecord struct {
+	// Time in milliseconds of the join the current deliveries belong to
+	JoinedAt int64
+
+	// Steps claimed for the current join, with the time in milliseconds they were claimed at
+	Steps map[int]int64
+
+	// Time in milliseconds of the last successful delivery, across all joins
+	LastDeliveredAt int64
+
+	// The variant of the message assigned to the user, kept across joins
+	Variant string `json:",omitempty"`
+
+	// Time in milliseconds of the first click on a button of the variant
+	VariantClickedAt int64 `json:",omitempty"`
+
+	// Time in milliseconds of the first delivery of the variant
+	VariantSentAt int64 `json:",omitempty"`
+}
+
+// ChannelDeliveryRecord is the ledger entry of the welcome message of a channel for a user
+type ChannelDeliveryRecord struct {
+	// Time in milliseconds of the last join of the channel
+	JoinedAt int64
+
+	// Time in milliseconds of the last successful delivery, across all joins
+	LastDeliveredAt int64
+}
+
+func getLedgerKey(userID, teamID, messageID string) string {
+	hash := sha256.Sum256([]byte(teamID + "/" + messageID))
+	return welcomebotLedgerKey + userID + "_" + hex.EncodeToString(hash[:16])
+}
+
+func decodeDeliveryRecord(data []byte) (*DeliveryRecord, error) {
+	record := &DeliveryRecord{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, record); err != nil {
+			return nil, err
+		}
+	}
+	if record.Steps == nil {
+		record.Steps = make(map[int]int64)
+	}
+
+	return record, nil
+}
+
+// rejoinAllowed tells whether a policy allows sending a message again, given the time in
+// milliseconds of its last delivery.
+func rejoinAllowed(policy string, afterDays int, lastDeliveredAt int64) bool {
+	if lastDeliveredAt == 0 {
+		return true
+	}
+
+	switch policy {
+	case rejoinPolicyNever:
+		return false
+	case rejoinPolicyAfterDays:
+		return time.Since(time.UnixMilli(lastDeliveredAt)) >= time.Duration(afterDays)*24*time.Hour
+	default:
+		return true
+	}
+}
+
+func (p *Plugin) getDeliveryRecord(userID, teamID, messageID string) (*DeliveryRecord, error) {
+	var data []byte
+	if err := p.client.KV.Get(getLedgerKey(userID, teamID, messageID), &data); err != nil {
+		return nil, errors.Wrap(err, "failed to get delivery record")
+	}
+
+	return decodeDeliveryRecord(data)
+}
+
+// startDeliveryRound records a join of the user to the team in the ledger of the message and
+// returns the join time to schedule the deliveries with. It fails with errDuplicateJoin or
+// errRejoinNotAllowed when the message must not be sent for this join, unless force is set.
+func (p *Plugin) startDeliveryRound(userID, teamID string, configMessage *ConfigMessage, force bool) (int64, error) {
+	joinedAt := model.GetMillis()
+	err := p.client.KV.SetAtomicWithRetries(getLedgerKey(userID, teamID, configMessage.ID), func(oldValue []byte) (interface{}, error) {
+		record, err := decodeDeliveryRecord(oldValue)
+		if err != nil {
+			return nil, err
+		}
+
+		if !force {
+			if joinedAt-record.JoinedAt < duplicateJoinWindow.Milliseconds() {
+				return nil, errDuplicateJoin
+			}
+			if !rejoinAllowed(configMessage.RejoinPolicy, configMessage.RejoinAfterDays, record.LastDeliveredAt) {
+				return nil, errRejoinNotAllowed
+			}
+		}
+
+		record.JoinedAt = joinedAt
+		record.Steps = make(map[int]int64)
+		return record, nil
+	})
+	if err != nil {
+		return 0, errors.Cause(err)
+	}
+
+	return joinedAt, nil
+}
+
+// claimDelivery atomically marks the step of a job as delivered, so that it is posted only once
+// even if the job runs several times. It fails with errAlreadyDelivered or errStaleDelivery when
+// the step must not be posted.
+func (p *Plugin) claimDelivery(job *WelcomeJob) error {
+	err := p.client.KV.SetAtomicWithRetries(getLedgerKey(job.UserID, job.TeamID, job.MessageID), func(oldValue []byte) (interface{}, error) {
+		record, err := decodeDeliveryRecord(oldValue)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case record.JoinedAt > job.JoinedAt:
+			return nil, errStaleDelivery
+		case record.JoinedAt < job.JoinedAt:
+			record.JoinedAt = job.JoinedAt
+			record.Steps = make(map[int]int64)
+		case record.Steps[job.Step] != 0:
+			return nil, errAlreadyDelivered
+		}
+
+		record.Steps[job.Step] = model.GetMillis()
+		return record, nil
+	})
+
+	return errors.Cause(err)
+}
+
+// completeDelivery records the successful delivery of a claimed step. firstVariantSend is true
+// when the step sent the variant of the job to the user for the first time.
+func (p *Plugin) completeDelivery(job *WelcomeJob) (firstVariantSend bool, err error) {
+	err = p.client.KV.SetAtomicWithRetries(getLedgerKey(job.UserID, job.TeamID, job.MessageID), func(oldValue []byte) (interface{}, error) {
+		record, err := decodeDeliveryRecord(oldValue)
+		if err != nil {
+			return nil, err
+		}
+
+		now := model.GetMillis()
+		record.LastDeliveredAt = now
+
+		// Variants only replace the top level message, which is the first step
+		firstVariantSend = job.Variant != "" && job.Step == 0 && record.Variant == job.Variant && record.VariantSentAt == 0
+		if firstVariantSend {
+			record.VariantSentAt = now
+		}
+		return record, nil
+	})
+
+	return firstVariantSend, err
+}
+
+// releaseDelivery gives up the claim on a step that could not be delivered.
+func (p *Plugin) releaseDelivery(job *WelcomeJob) error {
+	return p.client.KV.SetAtomicWithRetries(getLedgerKey(job.UserID, job.TeamID, job.MessageID), func(oldValue []byte) (interface{}, error) {
+		record, err := decodeDeliveryRecord(oldValue)
+		if err != nil {
+			return nil, err
+		}
+
+		if record.JoinedAt == job.JoinedAt {
+			delete(record.Steps, job.Step)
+		}
+		return record, nil
+	})
+}
+
+func getChannelLedgerKey(userID, channelID string) string {
+	return welcomebotChannelLedgerKey + userID + "_" + channelID
+}
+
+func decodeChannelDeliveryRecord(data []byte) (*ChannelDeliveryRecord, error) {
+	record := &ChannelDeliveryRecord{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, record); err != nil {
+			return nil, err
+		}
+	}
+
+	return record, nil
+}
+
+func (p *Plugin) getChannelDeliveryRecord(userID, channelID string) (*ChannelDeliveryRecord, error) {
+	var data []byte
+	if err := p.client.KV.Get(getChannelLedgerKey(userID, channelID), &data); err != nil {
+		return nil, errors.Wrap(err, "failed to get channel delivery record")
+	}
+
+	return decodeChannelDeliveryRecord(data)
+}
+
+// startChannelDeliveryRound records a join of the user to the channel and returns the join time.
+// It fails with errDuplicateJoin or errRejoinNotAllowed when the welcome message of the channel
+// must not be sent for this join.
+func (p *Plugin) startChannelDeliveryRound(userID, channelID string, welcome *ChannelWelcomeRecord) (int64, error) {
+	joinedAt := model.GetMillis()
+	err := p.client.KV.SetAtomicWithRetries(getChannelLedgerKey(userID, channelID), func(oldValue []byte) (interface{}, error) {
+		record, err := decodeChannelDeliveryRecord(oldValue)
+		if err != nil {
+			return nil, err
+		}
+
+		if joinedAt-record.JoinedAt < duplicateJoinWindow.Milliseconds() {
+			return nil, errDuplicateJoin
+		}
+		if !rejoinAllowed(welcome.RejoinPolicy, welcome.RejoinAfterDays, record.LastDeliveredAt) {
+			return nil, errRejoinNotAllowed
+		}
+
+		record.JoinedAt = joinedAt
+		return record, nil
+	})
+	if err != nil {
+		return 0, errors.Cause(err)
+	}
+
+	return joinedAt, nil
+}
+
+// completeChannelDelivery records the successful delivery of the welcome message of the channel
+func (p *Plugin) completeChannelDelivery(userID, channelID string) error {
+	return p.client.KV.SetAtomicWithRetries(getChannelLedgerKey(userID, channelID), func(oldValue []byte) (interface{}, error) {
+		record, err := decodeChannelDeliveryRecord(oldValue)
+		if err != nil {
+			return nil, err
+		}
+
+		record.LastDeliveredAt = model.GetMillis()
+		return record, nil
+	})
+}
+
+// resetChannelDelivery forgets the joins and deliveries of the welcome message of the channel to
+// the user, who then receives it on their next join whatever the rejoin policy
+func (p *Plugin) resetChannelDelivery(userID, channelID string) error {
+	return p.client.KV.Delete(getChannelLedgerKey(userID, channelID))
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestRejoinAllowed(t *testing.T) {
+	now := time.Now()
+	for name, tc := range map[string]struct {
+		policy          string
+		afterDays       int
+		lastDeliveredAt time.Time
+		expected        bool
+	}{
+		"never delivered":                {policy: rejoinPolicyNever, expected: true},
+		"default policy":                 {lastDeliveredAt: now, expected: true},
+		"always":                         {policy: rejoinPolicyAlways, lastDeliveredAt: now, expected: true},
+		"never":                          {policy: rejoinPolicyNever, lastDeliveredAt: now.AddDate(-1, 0, 0)},
+		"after days, too soon":           {policy: rejoinPolicyAfterDays, afterDays: 7, lastDeliveredAt: now.AddDate(0, 0, -6)},
+		"after days, long enough":        {policy: rejoinPolicyAfterDays, afterDays: 7, lastDeliveredAt: now.AddDate(0, 0, -7), expected: true},
+		"after zero days, just received": {policy: rejoinPolicyAfterDays, lastDeliveredAt: now, expected: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var lastDeliveredAt int64
+			if !tc.lastDeliveredAt.IsZero() {
+				lastDeliveredAt = tc.lastDeliveredAt.UnixMilli()
+			}
+			if allowed := rejoinAllowed(tc.policy, tc.afterDays, lastDeliveredAt); allowed != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, allowed)
+			}
+		})
+	}
+}
+
+// setDeliveryRecord stores the ledger entry of the message for the user of the team
+func setDeliveryRecord(t *testing.T, api *fakeAPI, userID, teamID, messageID string, record *DeliveryRecord) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	api.kv[getLedgerKey(userID, teamID, messageID)] = data
+}
+
+func TestStartDeliveryRound(t *testing.T) {
+	now := time.Now()
+	for name, tc := range map[string]struct {
+		record   *DeliveryRecord
+		policy   string
+		force    bool
+		expected error
+	}{
+		"first join": {},
+		"duplicate join": {
+			record:   &DeliveryRecord{JoinedAt: now.Add(-duplicateJoinWindow / 2).UnixMilli()},
+			expected: errDuplicateJoin,
+		},
+		"forced duplicate join": {
+			record: &DeliveryRecord{JoinedAt: now.Add(-duplicateJoinWindow / 2).UnixMilli()},
+			force:  true,
+		},
+		"rejoin after the window": {
+			record: &DeliveryRecord{JoinedAt: now.Add(-2 * duplicateJoinWindow).UnixMilli(), LastDeliveredAt: now.Add(-2 * duplicateJoinWindow).UnixMilli()},
+		},
+		"rejoin never allowed": {
+			record:   &DeliveryRecord{JoinedAt: now.AddDate(0, 0, -1).UnixMilli(), LastDeliveredAt: now.AddDate(0, 0, -1).UnixMilli()},
+			policy:   rejoinPolicyNever,
+			expected: errRejoinNotAllowed,
+		},
+		"forced rejoin never allowed": {
+			record: &DeliveryRecord{JoinedAt: now.AddDate(0, 0, -1).UnixMilli(), LastDeliveredAt: now.AddDate(0, 0, -1).UnixMilli()},
+			policy: rejoinPolicyNever,
+			force:  true,
+		},
+		"rejoin never allowed, never delivered": {
+			record: &DeliveryRecord{JoinedAt: now.AddDate(0, 0, -1).UnixMilli()},
+			policy: rejoinPolicyNever,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			api := &fakeAPI{}
+			p := newTestPlugin(api)
+			message := &ConfigMessage{ID: "message-id", RejoinPolicy: tc.policy}
+			if tc.record != nil {
+				tc.record.Steps = map[int]int64{0: tc.record.JoinedAt}
+				setDeliveryRecord(t, api, "user-id", "team-id", message.ID, tc.record)
+			}
+
+			joinedAt, err := p.startDeliveryRound("user-id", "team-id", message, tc.force)
+			if err != tc.expected {
+				t.Fatalf("expected error %v, got %v", tc.expected, err)
+			}
+
+			record, err := p.getDeliveryRecord("user-id", "team-id", message.ID)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if tc.expected != nil {
+				if record.JoinedAt != tc.record.JoinedAt || len(record.Steps) != 1 {
+					t.Errorf("expected the record to be unchanged, got %+v", record)
+				}
+				return
+			}
+			if record.JoinedAt != joinedAt || len(record.Steps) != 0 {
+				t.Errorf("expected a new round joined at %d, got %+v", joinedAt, record)
+			}
+		})
+	}
+}
+
+func TestClaimDelivery(t *testing.T) {
+	joinedAt := model.GetMillis()
+	job := func(step int, joinedAt int64) *WelcomeJob {
+		return &WelcomeJob{UserID: "user-id", TeamID: "team-id", MessageID: "message-id", Step: step, JoinedAt: joinedAt}
+	}
+
+	for name, tc := range map[string]struct {
+		record        *DeliveryRecord
+		job           *WelcomeJob
+		expected      error
+		expectedSteps []int
+	}{
+		"no record": {
+			job:           job(0, joinedAt),
+			expectedSteps: []int{0},
+		},
+		"first claim of the step": {
+			record:        &DeliveryRecord{JoinedAt: joinedAt, Steps: map[int]int64{0: joinedAt}},
+			job:           job(1, joinedAt),
+			expectedSteps: []int{0, 1},
+		},
+		"already delivered": {
+			record:        &DeliveryRecord{JoinedAt: joinedAt, Steps: map[int]int64{0: joinedAt}},
+			job:           job(0, joinedAt),
+			expected:      errAlreadyDelivered,
+			expectedSteps: []int{0},
+		},
+		"stale": {
+			record:        &DeliveryRecord{JoinedAt: joinedAt, Steps: map[int]int64{0: joinedAt}},
+			job:           job(1, joinedAt-1000),
+			expected:      errStaleDelivery,
+			expectedSteps: []int{0},
+		},
+		"later join": {
+			record:        &DeliveryRecord{JoinedAt: joinedAt - 1000, Steps: map[int]int64{0: joinedAt - 1000, 1: joinedAt - 1000}},
+			job:           job(1, joinedAt),
+			expectedSteps: []int{1},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			api := &fakeAPI{}
+			p := newTestPlugin(api)
+			if tc.record != nil {
+				setDeliveryRecord(t, api, "user-id", "team-id", "message-id", tc.record)
+			}
+
+			if err := p.claimDelivery(tc.job); err != tc.expected {
+				t.Fatalf("expected error %v, got %v", tc.expected, err)
+			}
+
+			record, err := p.getDeliveryRecord("user-id", "team-id", "message-id")
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if len(record.Steps) != len(tc.expectedSteps) {
+				t.Fatalf("expected steps %v to be claimed, got %v", tc.expectedSteps, record.Steps)
+			}
+			for _, step := range tc.expectedSteps {
+				if record.Steps[step] == 0 {
+					t.Errorf("expected steps %v to be claimed, got %v", tc.expectedSteps, record.Steps)
+				}
+			}
+		})
+	}
+}
+
+func TestReleaseDelivery(t *testing.T) {
+	api := &fakeAPI{}
+	p := newTestPlugin(api)
+	joinedAt := model.GetMillis()
+	job := &WelcomeJob{UserID: "user-id", TeamID: "team-id", MessageID: "message-id", Step: 0, JoinedAt: joinedAt}
+
+	if err := p.claimDelivery(job); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := p.releaseDelivery(job); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := p.claimDelivery(job); err != nil {
+		t.Fatalf("expected the released step to be claimable again, got %v", err)
+	}
+
+	// A job of an earlier join doesn't release the claim of the current join
+	staleJob := *job
+	staleJob.JoinedAt = joinedAt - 1000
+	if err := p.releaseDelivery(&staleJob); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := p.claimDelivery(job); err != errAlreadyDelivered {
+		t.Errorf("expected %v, got %v", errAlreadyDelivered, err)
+	}
+}
+
+func TestDuplicateJoinDeliversOnce(t *testing.T) {
+	p := newTestPlugin(&fakeAPI{})
+	message := &ConfigMessage{ID: "message-id"}
+
+	joinedAt, err := p.startDeliveryRound("user-id", "team-id", message, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err = p.startDeliveryRound("user-id", "team-id", message, false); err != errDuplicateJoin {
+		t.Fatalf("expected %v, got %v", errDuplicateJoin, err)
+	}
+
+	// Both nodes handling the join run the job, only one of them delivers it
+	job := &WelcomeJob{UserID: "user-id", TeamID: "team-id", MessageID: message.ID, JoinedAt: joinedAt}
+	if err := p.claimDelivery(job); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := p.claimDelivery(job); err != errAlreadyDelivered {
+		t.Errorf("expected %v, got %v", errAlreadyDelivered, err)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// normalizeLocale makes locales comparable, e.g. pt_BR and pt-br both become pt-br
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+}
+
+// localeFallbacks lists the locales to look translations up for, in order: each given locale
+// followed by its base language, e.g. pt-BR then pt. Empty and repeated locales are skipped.
+func localeFallbacks(locales ...string) []string {
+	var fallbacks []string
+	seen := make(map[string]bool)
+	add := func(locale string) {
+		if locale != "" && !seen[locale] {
+			seen[locale] = true
+			fallbacks = append(fallbacks, locale)
+		}
+	}
+
+	for _, locale := range locales {
+		locale = normalizeLocale(locale)
+		add(locale)
+		if base, _, found := strings.Cut(locale, "-"); found {
+			add(base)
+		}
+	}
+
+	return fallbacks
+}
+
+// getLocaleFallbacks lists the locales to look translations up for the user: the locale of the
+// user, then the default locale of the server.
+func (p *Plugin) getLocaleFallbacks(user *model.User) []string {
+	var userLocale, defaultLocale string
+	if user != nil {
+		userLocale = user.Locale
+	}
+	if config := p.API.GetConfig(); config != nil && config.LocalizationSettings.DefaultClientLocale != nil {
+		defaultLocale = *config.LocalizationSettings.DefaultClientLocale
+	}
+
+	return localeFallbacks(userLocale, defaultLocale)
+}
+
+// normalizeTranslations normalizes the locales of channel welcome translations and drops the
+// empty ones
+func normalizeTranslations(translations map[string]string) map[string]string {
+	normalized := make(map[string]string, len(translations))
+	for locale, message := range translations {
+		if message = strings.TrimSpace(message); message != "" && normalizeLocale(locale) != "" {
+			normalized[normalizeLocale(locale)] = message
+		}
+	}
+
+	return normalized
+}
+
+// findTranslation returns the translation for the first of the fallbacks which has one
+func findTranslation[T any](translations map[string]T, fallbacks []string) (T, bool) {
+	var none T
+	if len(translations) == 0 {
+		return none, false
+	}
+
+	normalized := make(map[string]T, len(translations))
+	for locale, translation := range translations {
+		normalized[normalizeLocale(locale)] = translation
+	}
+
+	for _, locale := range fallbacks {
+		if translation, ok := normalized[locale]; ok {
+			return translation, true
+		}
+	}
+
+	return none, false
+}
+
+// localized returns a copy of the message with the content and actions translated for the first
+// of the fallbacks which has a translation. Untranslated content is kept.
+func (m ConfigMessage) localized(fallbacks []string) ConfigMessage {
+	if translation, ok := findTranslation(m.Translations, fallbacks); ok && translation != nil {
+		if len(translation.Message) > 0 {
+			m.Message = translation.Message
+		}
+		if len(translation.AttachmentMessage) > 0 {
+			m.AttachmentMessage = translation.AttachmentMessage
+		}
+	}
+
+	actions := make([]*ConfigMessageAction, 0, len(m.Actions))
+	for _, action := range m.Actions {
+		actions = append(actions, action.localized(fallbacks))
+	}
+	m.Actions = actions
+
+	return m
+}
+
+// localized returns a copy of the action translated for the first of the fallbacks which has a
+// translation. Untranslated fields are kept.
+func (a *ConfigMessageAction) localized(fallbacks []string) *ConfigMessageAction {
+	action := *a
+	if translation, ok := findTranslation(a.Translations, fallbacks); ok && translation != nil {
+		if translation.ActionDisplayName != "" {
+			action.ActionDisplayName = translation.ActionDisplayName
+		}
+		if len(translation.ActionSuccessfulMessage) > 0 {
+			action.ActionSuccessfulMessage = translation.ActionSuccessfulMessage
+		}
+	}
+
+	return &action
+}
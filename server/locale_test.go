@@ -0,0 +1,66 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLocaleFallbacks(t *testing.T) {
+	for name, tc := range map[string]struct {
+		locales  []string
+		expected []string
+	}{
+		"no locale":            {locales: []string{"", ""}, expected: nil},
+		"language only":        {locales: []string{"fr", "en"}, expected: []string{"fr", "en"}},
+		"region":               {locales: []string{"pt_BR", "en"}, expected: []string{"pt-br", "pt", "en"}},
+		"same as default":      {locales: []string{"en", "en"}, expected: []string{"en"}},
+		"region of default":    {locales: []string{"ja", "zh-TW"}, expected: []string{"ja", "zh-tw", "zh"}},
+		"default without user": {locales: []string{"", "de"}, expected: []string{"de"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if fallbacks := localeFallbacks(tc.locales...); !reflect.DeepEqual(fallbacks, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, fallbacks)
+			}
+		})
+	}
+}
+
+func TestConfigMessageLocalized(t *testing.T) {
+	message := ConfigMessage{
+		Message:           []string{"Welcome!"},
+		AttachmentMessage: []string{"Pick your channels"},
+		Translations: map[string]*ConfigMessageTranslation{
+			"fr":    {Message: []string{"Bienvenue !"}},
+			"pt-BR": {Message: []string{"Bem-vindo!"}, AttachmentMessage: []string{"Escolha seus canais"}},
+		},
+		Actions: []*ConfigMessageAction{{
+			ActionType:        actionTypeButton,
+			ActionName:        "dev",
+			ActionDisplayName: "Developer",
+			Translations: map[string]*ConfigActionTranslation{
+				"fr": {ActionDisplayName: "Développeur"},
+			},
+		}},
+	}
+
+	french := message.localized(localeFallbacks("fr-CA", "en"))
+	if french.Message[0] != "Bienvenue !" || french.AttachmentMessage[0] != "Pick your channels" {
+		t.Errorf("unexpected French message %v / %v", french.Message, french.AttachmentMessage)
+	}
+	if french.Actions[0].ActionDisplayName != "Développeur" || french.Actions[0].ActionName != "dev" {
+		t.Errorf("unexpected French action %+v", french.Actions[0])
+	}
+	if message.Actions[0].ActionDisplayName != "Developer" {
+		t.Error("the original action has been modified")
+	}
+
+	brazilian := message.localized(localeFallbacks("pt_BR", "en"))
+	if brazilian.Message[0] != "Bem-vindo!" || brazilian.AttachmentMessage[0] != "Escolha seus canais" {
+		t.Errorf("unexpected Brazilian message %v / %v", brazilian.Message, brazilian.AttachmentMessage)
+	}
+
+	german := message.localized(localeFallbacks("de", "en"))
+	if german.Message[0] != "Welcome!" || german.Actions[0].ActionDisplayName != "Developer" {
+		t.Errorf("unexpected default message %v / %+v", german.Message, german.Actions[0])
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"unicode"
+)
+
+// escapeMarkdownFunc is the name of the template function appended to the pipeline of every
+// action of the templates, so that all the printed values are escaped
+const escapeMarkdownFunc = "_escapeMarkdown"
+
+// markdown is a value known to be safe Markdown, which is printed as-is by the templates. It is
+// returned by the template functions producing links and mentions.
+type markdown string
+
+// Mentions notifying a whole channel, which are neutralized in the printed values
+var channelMentionRegexp = regexp.MustCompile(`(?i)@(channel|all|here)\b`)
+
+// escapeMarkdownTemplate adds the escaping of the printed values to all the actions of the
+// template, in the manner of html/template. The text written by the author is left untouched.
+func escapeMarkdownTemplate(tmpl *template.Template) {
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			escapeMarkdownNode(t.Tree.Root)
+		}
+	}
+}
+
+func escapeMarkdownNode(node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			escapeMarkdownNode(child)
+		}
+	case *parse.ActionNode:
+		// Variable declarations print nothing
+		if len(n.Pipe.Decl) > 0 {
+			return
+		}
+		n.Pipe.Cmds = append(n.Pipe.Cmds, &parse.CommandNode{
+			NodeType: parse.NodeCommand,
+			Pos:      n.Pos,
+			Args:     []parse.Node{parse.NewIdentifier(escapeMarkdownFunc).SetTree(nil).SetPos(n.Pos)},
+		})
+	case *parse.IfNode:
+		escapeMarkdownNode(n.List)
+		escapeMarkdownNode(n.ElseList)
+	case *parse.RangeNode:
+		escapeMarkdownNode(n.List)
+		escapeMarkdownNode(n.ElseList)
+	case *parse.WithNode:
+		escapeMarkdownNode(n.List)
+		escapeMarkdownNode(n.ElseList)
+	}
+}
+
+// escapeMarkdownValue prints a value like text/template does, escaped for Markdown
+func escapeMarkdownValue(args ...interface{}) string {
+	if len(args) == 1 {
+		if value, ok := args[0].(markdown); ok {
+			return string(value)
+		}
+	}
+
+	return escapeMarkdown(fmt.Sprint(args...))
+}
+
+// escapeMarkdown escapes the characters of s which Markdown would interpret, and neutralizes the
+// @channel, @all and @here mentions. Underscores inside words are kept, as they are not
+// interpreted by Markdown and are common in usernames.
+func escapeMarkdown(s string) string {
+	runes := []rune(s)
+
+	var escaped strings.Builder
+	for i, r := range runes {
+		switch r {
+		case '\\', '`', '*', '~', '[', ']', '<', '>', '|', '#', '&':
+			escaped.WriteRune('\\')
+		case '_':
+			if i == 0 || i == len(runes)-1 || !isWordRune(runes[i-1]) || !isWordRune(runes[i+1]) {
+				escaped.WriteRune('\\')
+			}
+		}
+		escaped.WriteRune(r)
+	}
+
+	// A zero width space after the @ prevents the mention without changing how it looks
+	return channelMentionRegexp.ReplaceAllString(escaped.String(), "@\u200b$1")
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
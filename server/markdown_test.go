@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestEscapeMarkdown(t *testing.T) {
+	for name, tc := range map[string]struct {
+		value    string
+		expected string
+	}{
+		"plain text":            {value: "O'Brien & Sons", expected: `O'Brien \& Sons`},
+		"emphasis":              {value: "*bold* _italic_", expected: `\*bold\* \_italic\_`},
+		"underscore in a word":  {value: "john_doe", expected: "john_doe"},
+		"link":                  {value: "[click](http://example.com)", expected: `\[click\](http://example.com)`},
+		"html":                  {value: "<b>hi</b>", expected: `\<b\>hi\</b\>`},
+		"code":                  {value: "`code`", expected: "\\`code\\`"},
+		"heading":               {value: "# Title", expected: `\# Title`},
+		"channel mention":       {value: "hi @channel", expected: "hi @\u200bchannel"},
+		"all and here mentions": {value: "@ALL and @here!", expected: "@\u200bALL and @\u200bhere!"},
+		"user mention":          {value: "@channelmanager", expected: "@channelmanager"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if escaped := escapeMarkdown(tc.value); escaped != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, escaped)
+			}
+		})
+	}
+}
+
+func TestParseTemplateEscapesValues(t *testing.T) {
+	p := &Plugin{}
+	data := &MessageTemplate{
+		User:            &model.User{Username: "obrien", Nickname: "@here"},
+		UserDisplayName: "*O'Brien*",
+	}
+
+	for name, tc := range map[string]struct {
+		template string
+		expected string
+	}{
+		"author text is untouched": {
+			template: "### Welcome **{{.UserDisplayName}}** & enjoy!",
+			expected: `### Welcome **\*O'Brien\*** & enjoy!`,
+		},
+		"mention injection": {
+			template: "Hi {{.User.Nickname}}",
+			expected: "Hi @\u200bhere",
+		},
+		"markdown helpers": {
+			template: "{{mention .User}} {{channelLink \"town-square\"}}",
+			expected: "@obrien ~town-square",
+		},
+		"conditions and variables": {
+			template: "{{$name := .UserDisplayName}}{{if .User}}{{$name | lower}}{{end}}",
+			expected: `\*o'brien\*`,
+		},
+		"with": {
+			template: "{{with .User}}{{.Username}}{{end}}",
+			expected: "obrien",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tmpl, err := p.parseTemplate("Test", []string{tc.template})
+			if err != nil {
+				t.Fatalf("failed to parse the template: %v", err)
+			}
+
+			var rendered strings.Builder
+			if err := tmpl.Execute(&rendered, data); err != nil {
+				t.Fatalf("failed to execute the template: %v", err)
+			}
+			if rendered.String() != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, rendered.String())
+			}
+		})
+	}
+}
@@ -1,13 +1,273 @@
 package main
 
-import "github.com/mattermost/mattermost/server/public/model"
+import (
+	"sync"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+)
+
+// Maximum number of admins listed by MessageTemplate.TeamAdmins and ChannelMessageTemplate.ChannelAdmins
+const maxTeamAdmins = 100
 
 // MessageTemplate represents all the data that can be used in the template for a welcomebot message
 type MessageTemplate struct {
-	WelcomeBot      *model.User
 	User            *model.User
 	Team            *model.Team
 	Townsquare      *model.Channel
 	DirectMessage   *model.Channel
 	UserDisplayName string
+
+	// Data loaded only when a template uses it, shared by the copies of the template data
+	lazy *lazyTemplateData
+}
+
+// ChannelMessageTemplate represents all the data that can be used in the template for a channel
+// welcome message. The user who added the user to the channel is given by Actor.
+type ChannelMessageTemplate struct {
+	MessageTemplate
+
+	Channel *model.Channel
+}
+
+// ChannelAdmins lists the active admins of the channel
+func (m ChannelMessageTemplate) ChannelAdmins() []*model.User {
+	if m.lazy == nil || m.Channel == nil {
+		return nil
+	}
+
+	admins, _ := m.lazy.load("ChannelAdmins", func() (interface{}, error) {
+		return m.lazy.sanitizeUsersResult(m.lazy.api.GetUsers(&model.UserGetOptions{
+			InChannelId:  m.Channel.Id,
+			ChannelRoles: []string{model.ChannelAdminRoleId},
+			Active:       true,
+			PerPage:      maxTeamAdmins,
+		}))
+	}).([]*model.User)
+	return admins
+}
+
+// lazyTemplateData loads and caches the data of the accessors of MessageTemplate
+type lazyTemplateData struct {
+	api       plugin.API
+	botUserID string
+
+	// The user who added the user to the team, if any
+	actorID string
+
+	// The privacy options of the server, set when the users loaded must be sanitized
+	sanitizeOptions map[string]bool
+
+	lock           sync.Mutex
+	values         map[string]interface{}
+	joinedChannels []*model.Channel
+}
+
+func (p *Plugin) newLazyTemplateData() *lazyTemplateData {
+	return &lazyTemplateData{
+		api:       p.API,
+		botUserID: p.botUserID,
+		values:    make(map[string]interface{}),
+	}
+}
+
+// load returns the cached value with the given name, calling fetch the first time. Failures are
+// logged and cached as a nil value.
+func (l *lazyTemplateData) load(name string, fetch func() (interface{}, error)) interface{} {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if value, ok := l.values[name]; ok {
+		return value
+	}
+
+	value, err := fetch()
+	if err != nil {
+		l.api.LogError("failed to load template data", "name", name, "err", err.Error())
+		value = nil
+	}
+	l.values[name] = value
+
+	return value
+}
+
+// sanitizeUsers removes the private fields, per the privacy settings of the server, from the users
+// given by the accessors of the template data
+func (l *lazyTemplateData) sanitizeUsers(config *model.Config) {
+	if config == nil {
+		config = &model.Config{}
+		config.SetDefaults()
+	}
+	l.sanitizeOptions = config.GetSanitizeOptions()
+}
+
+// sanitizeUser returns a sanitized copy of the user when the users must be sanitized
+func (l *lazyTemplateData) sanitizeUser(user *model.User) *model.User {
+	if l.sanitizeOptions == nil || user == nil {
+		return user
+	}
+
+	sanitized := *user
+	sanitized.Props = model.StringMap{}
+	for key, value := range user.Props {
+		sanitized.Props[key] = value
+	}
+	sanitized.SanitizeProfile(l.sanitizeOptions)
+	return &sanitized
+}
+
+// sanitizeUserResult sanitizes the user returned by an API call for the lazy loader
+func (l *lazyTemplateData) sanitizeUserResult(user *model.User, appErr *model.AppError) (interface{}, error) {
+	return appResult(l.sanitizeUser(user), appErr)
+}
+
+// sanitizeUsersResult sanitizes the users returned by an API call for the lazy loader
+func (l *lazyTemplateData) sanitizeUsersResult(users []*model.User, appErr *model.AppError) (interface{}, error) {
+	for i, user := range users {
+		users[i] = l.sanitizeUser(user)
+	}
+	return appResult(users, appErr)
+}
+
+// setActor records the user who added the user to the team, or to the channel
+func (m MessageTemplate) setActor(actorID string) {
+	if m.lazy != nil {
+		m.lazy.actorID = actorID
+	}
+}
+
+// actorID returns the ID of the user who added the user to the team, if any
+func (m MessageTemplate) actorID() string {
+	if m.lazy == nil {
+		return ""
+	}
+
+	return m.lazy.actorID
+}
+
+// addJoinedChannel records a channel the user has been added to while welcoming them
+func (m MessageTemplate) addJoinedChannel(channel *model.Channel) {
+	if m.lazy == nil {
+		return
+	}
+
+	m.lazy.lock.Lock()
+	defer m.lazy.lock.Unlock()
+	m.lazy.joinedChannels = append(m.lazy.joinedChannels, channel)
+}
+
+// WelcomeBot is the bot account sending the messages
+func (m MessageTemplate) WelcomeBot() *model.User {
+	if m.lazy == nil {
+		return nil
+	}
+
+	bot, _ := m.lazy.load("WelcomeBot", func() (interface{}, error) {
+		return m.lazy.sanitizeUserResult(m.lazy.api.GetUser(m.lazy.botUserID))
+	}).(*model.User)
+	return bot
+}
+
+// Actor is the user who added the user to the team, or to the channel for channel welcome messages.
+// It is nil if the user joined by themselves.
+func (m MessageTemplate) Actor() *model.User {
+	if m.lazy == nil || m.lazy.actorID == "" || (m.User != nil && m.lazy.actorID == m.User.Id) {
+		return nil
+	}
+
+	actor, _ := m.lazy.load("Actor", func() (interface{}, error) {
+		return m.lazy.sanitizeUserResult(m.lazy.api.GetUser(m.lazy.actorID))
+	}).(*model.User)
+	return actor
+}
+
+// TeamAdmins lists the active admins of the team
+func (m MessageTemplate) TeamAdmins() []*model.User {
+	if m.lazy == nil || m.Team == nil {
+		return nil
+	}
+
+	admins, _ := m.lazy.load("TeamAdmins", func() (interface{}, error) {
+		return m.lazy.sanitizeUsersResult(m.lazy.api.GetUsers(&model.UserGetOptions{
+			InTeamId:  m.Team.Id,
+			TeamRoles: []string{model.TeamAdminRoleId},
+			Active:    true,
+			PerPage:   maxTeamAdmins,
+		}))
+	}).([]*model.User)
+	return admins
+}
+
+// TeamMemberCount is the number of active members of the team
+func (m MessageTemplate) TeamMemberCount() int64 {
+	if m.lazy == nil || m.Team == nil {
+		return 0
+	}
+
+	stats, _ := m.lazy.load("TeamMemberCount", func() (interface{}, error) {
+		return appResult(m.lazy.api.GetTeamStats(m.Team.Id))
+	}).(*model.TeamStats)
+	if stats == nil {
+		return 0
+	}
+	return stats.ActiveMemberCount
+}
+
+// JoinedChannels lists the channels the user has been added to by the actions of the message
+func (m MessageTemplate) JoinedChannels() []*model.Channel {
+	if m.lazy == nil {
+		return nil
+	}
+
+	m.lazy.lock.Lock()
+	defer m.lazy.lock.Unlock()
+	return append([]*model.Channel{}, m.lazy.joinedChannels...)
+}
+
+// Locale is the language of the user, or the default language of the server
+func (m MessageTemplate) Locale() string {
+	if m.User != nil && m.User.Locale != "" {
+		return m.User.Locale
+	}
+	if m.lazy == nil {
+		return ""
+	}
+
+	locale, _ := m.lazy.load("Locale", func() (interface{}, error) {
+		config := m.lazy.api.GetConfig()
+		if config == nil || config.LocalizationSettings.DefaultClientLocale == nil {
+			return "", nil
+		}
+		return *config.LocalizationSettings.DefaultClientLocale, nil
+	}).(string)
+	return locale
+}
+
+// Timezone is the name of the timezone of the user, e.g. Europe/Paris
+func (m MessageTemplate) Timezone() string {
+	if m.User == nil {
+		return ""
+	}
+
+	return m.User.GetPreferredTimezone()
+}
+
+// Attribute returns the value of a custom profile attribute of the user, stored in the props of the
+// user, or an empty string
+func (m MessageTemplate) Attribute(name string) string {
+	if m.User == nil {
+		return ""
+	}
+
+	value, _ := m.User.GetProp(name)
+	return value
+}
+
+// appResult converts the result of an API call for the lazy loader
+func appResult[T any](value T, appErr *model.AppError) (interface{}, error) {
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	return value, nil
 }
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestSanitizeUser(t *testing.T) {
+	user := &model.User{
+		Id:        "user-id",
+		Username:  "bob",
+		Email:     "bob@example.com",
+		FirstName: "Bob",
+		LastName:  "Smith",
+		Password:  "hash",
+		Props:     model.StringMap{"department": "sales"},
+	}
+
+	for name, tc := range map[string]struct {
+		config        *model.Config
+		expectedEmail string
+		expectedFirst string
+	}{
+		"not sanitized": {expectedEmail: "bob@example.com", expectedFirst: "Bob"},
+		"privacy":       {config: &model.Config{PrivacySettings: model.PrivacySettings{ShowEmailAddress: model.NewBool(false), ShowFullName: model.NewBool(false)}}},
+		"full name":     {config: &model.Config{PrivacySettings: model.PrivacySettings{ShowEmailAddress: model.NewBool(false), ShowFullName: model.NewBool(true)}}, expectedFirst: "Bob"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			lazy := &lazyTemplateData{}
+			if tc.config != nil {
+				lazy.sanitizeUsers(tc.config)
+			}
+
+			sanitized := lazy.sanitizeUser(user)
+			if sanitized.Email != tc.expectedEmail || sanitized.FirstName != tc.expectedFirst {
+				t.Errorf("expected email %q and first name %q, got %q and %q", tc.expectedEmail, tc.expectedFirst, sanitized.Email, sanitized.FirstName)
+			}
+			if tc.config != nil && sanitized.Password != "" {
+				t.Error("expected the password to be removed")
+			}
+			if sanitized.Username != "bob" || sanitized.Props["department"] != "sales" {
+				t.Errorf("expected the profile to be kept, got %+v", sanitized)
+			}
+		})
+	}
+
+	if user.Email != "bob@example.com" || user.Password != "hash" {
+		t.Error("expected the original user to be left unchanged")
+	}
+}
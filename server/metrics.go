@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	welcomeKindTeam    = "team"
+	welcomeKindChannel = "channel"
+)
+
+// Upper bounds in seconds of the buckets of the delivery latency histogram
+var deliveryLatencyBuckets = []float64{1, 5, 15, 60, 300, 900, 3600}
+
+// metrics counts the deliveries handled by a node since the plugin started. The zero value is
+// ready to use. Counters are exposed in the Prometheus text format, and summed across the nodes
+// of a cluster by Prometheus.
+type metrics struct {
+	lock sync.Mutex
+
+	// Welcomes by kind, team or channel
+	welcomesSent   map[string]int64
+	welcomesFailed map[string]int64
+
+	templateErrors      int64
+	channelJoinFailures int64
+
+	// Clicks by action name
+	actionClicks map[string]int64
+
+	// Seconds between the time a welcome message was due and the time it was posted
+	latencyBuckets []int64
+	latencySum     float64
+	latencyCount   int64
+}
+
+func (m *metrics) incWelcomesSent(kind string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.welcomesSent == nil {
+		m.welcomesSent = make(map[string]int64)
+	}
+	m.welcomesSent[kind]++
+}
+
+func (m *metrics) incWelcomesFailed(kind string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.welcomesFailed == nil {
+		m.welcomesFailed = make(map[string]int64)
+	}
+	m.welcomesFailed[kind]++
+}
+
+func (m *metrics) incTemplateErrors() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.templateErrors++
+}
+
+func (m *metrics) incChannelJoinFailures() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.channelJoinFailures++
+}
+
+func (m *metrics) incActionClicks(actionName string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.actionClicks == nil {
+		m.actionClicks = make(map[string]int64)
+	}
+	m.actionClicks[actionName]++
+}
+
+func (m *metrics) observeDeliveryLatency(latency time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.latencyBuckets == nil {
+		m.latencyBuckets = make([]int64, len(deliveryLatencyBuckets))
+	}
+
+	seconds := latency.Seconds()
+	for i, bound := range deliveryLatencyBuckets {
+		if seconds <= bound {
+			m.latencyBuckets[i]++
+		}
+	}
+	m.latencySum += seconds
+	m.latencyCount++
+}
+
+// write writes the metrics in the Prometheus text format. The queue depth is not known to the
+// counters and is given by the caller, a negative value omitting it.
+func (m *metrics) write(w io.Writer, queueDepth int) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var str strings.Builder
+	writeCounterByLabel(&str, "welcomebot_welcomes_sent_total", "Welcome messages posted.", "kind", m.welcomesSent, welcomeKindTeam, welcomeKindChannel)
+	writeCounterByLabel(&str, "welcomebot_welcomes_failed_total", "Welcome messages which could not be posted.", "kind", m.welcomesFailed, welcomeKindTeam, welcomeKindChannel)
+	writeCounter(&str, "welcomebot_template_errors_total", "Message templates which failed to render.", m.templateErrors)
+	writeCounter(&str, "welcomebot_channel_join_failures_total", "Users who could not be added to a channel by an action.", m.channelJoinFailures)
+	writeCounterByLabel(&str, "welcomebot_action_clicks_total", "Clicks on the buttons of the welcome messages.", "action", m.actionClicks)
+
+	if queueDepth >= 0 {
+		str.WriteString("# HELP welcomebot_queue_depth Welcome messages scheduled and not delivered yet, across the cluster.\n")
+		str.WriteString("# TYPE welcomebot_queue_depth gauge\n")
+		str.WriteString(fmt.Sprintf("welcomebot_queue_depth %d\n", queueDepth))
+	}
+
+	str.WriteString("# HELP welcomebot_delivery_latency_seconds Time between the moment a welcome message was due and the moment it was posted.\n")
+	str.WriteString("# TYPE welcomebot_delivery_latency_seconds histogram\n")
+	for i, bound := range deliveryLatencyBuckets {
+		var count int64
+		if m.latencyBuckets != nil {
+			count = m.latencyBuckets[i]
+		}
+		str.WriteString(fmt.Sprintf("welcomebot_delivery_latency_seconds_bucket{le=%q} %d\n", strconv.FormatFloat(bound, 'g', -1, 64), count))
+	}
+	str.WriteString(fmt.Sprintf("welcomebot_delivery_latency_seconds_bucket{le=\"+Inf\"} %d\n", m.latencyCount))
+	str.WriteString(fmt.Sprintf("welcomebot_delivery_latency_seconds_sum %s\n", strconv.FormatFloat(m.latencySum, 'g', -1, 64)))
+	str.WriteString(fmt.Sprintf("welcomebot_delivery_latency_seconds_count %d\n", m.latencyCount))
+
+	_, err := io.WriteString(w, str.String())
+	return err
+}
+
+func writeCounter(str *strings.Builder, name, help string, value int64) {
+	str.WriteString(fmt.Sprintf("# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value))
+}
+
+// writeCounterByLabel writes a counter with one series per label value. The given label values are
+// always written, so that the series exist before their first increment.
+func writeCounterByLabel(str *strings.Builder, name, help, label string, values map[string]int64, labelValues ...string) {
+	str.WriteString(fmt.Sprintf("# HELP %s %s\n# TYPE %s counter\n", name, help, name))
+
+	seen := make(map[string]bool)
+	for _, labelValue := range labelValues {
+		seen[labelValue] = true
+	}
+	for labelValue := range values {
+		if !seen[labelValue] {
+			labelValues = append(labelValues, labelValue)
+		}
+	}
+	sort.Strings(labelValues)
+
+	for _, labelValue := range labelValues {
+		str.WriteString(fmt.Sprintf("%s{%s=%s} %d\n", name, label, quoteLabelValue(labelValue), values[labelValue]))
+	}
+}
+
+// quoteLabelValue escapes a label value as required by the Prometheus text format
+func quoteLabelValue(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value) + `"`
+}
+
+// handleMetrics serves the metrics to the holders of the metrics token and to system admins
+func (p *Plugin) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !p.canReadMetrics(r) {
+		http.Error(w, "not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	queueDepth := -1
+	if p.scheduler != nil {
+		jobs, err := p.scheduler.ListScheduledJobs()
+		if err != nil {
+			p.API.LogError("failed to list the scheduled welcome messages", "err", err.Error())
+		} else {
+			queueDepth = len(jobs)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := p.metrics.write(w, queueDepth); err != nil {
+		p.API.LogWarn("failed to write metrics", "error", err.Error())
+	}
+}
+
+// canReadMetrics tells whether the request has the metrics token in the token query parameter, or
+// comes from a system admin. The token can't be sent in the Authorization header, which Mattermost
+// consumes as a session token and removes before passing the request to the plugin.
+func (p *Plugin) canReadMetrics(r *http.Request) bool {
+	token, _ := p.metricsToken.Load().(string)
+	if token != "" && subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(token)) == 1 {
+		return true
+	}
+
+	userID := r.Header.Get("Mattermost-User-Id")
+	return userID != "" && p.isSysadmin(userID)
+}
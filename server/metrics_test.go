@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsWrite(t *testing.T) {
+	var m metrics
+	m.incWelcomesSent(welcomeKindTeam)
+	m.incWelcomesSent(welcomeKindTeam)
+	m.incWelcomesFailed(welcomeKindChannel)
+	m.incTemplateErrors()
+	m.incChannelJoinFailures()
+	m.incActionClicks(`say "hi"`)
+	m.observeDeliveryLatency(3 * time.Second)
+	m.observeDeliveryLatency(2 * time.Hour)
+
+	var buffer bytes.Buffer
+	if err := m.write(&buffer, 4); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	output := buffer.String()
+
+	for _, expected := range []string{
+		`welcomebot_welcomes_sent_total{kind="channel"} 0`,
+		`welcomebot_welcomes_sent_total{kind="team"} 2`,
+		`welcomebot_welcomes_failed_total{kind="channel"} 1`,
+		"welcomebot_template_errors_total 1",
+		"welcomebot_channel_join_failures_total 1",
+		`welcomebot_action_clicks_total{action="say \"hi\""} 1`,
+		"# TYPE welcomebot_queue_depth gauge\nwelcomebot_queue_depth 4",
+		`welcomebot_delivery_latency_seconds_bucket{le="1"} 0`,
+		`welcomebot_delivery_latency_seconds_bucket{le="5"} 1`,
+		`welcomebot_delivery_latency_seconds_bucket{le="3600"} 1`,
+		`welcomebot_delivery_latency_seconds_bucket{le="+Inf"} 2`,
+		"welcomebot_delivery_latency_seconds_sum 7203",
+		"welcomebot_delivery_latency_seconds_count 2",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected the output to contain %q, got:\n%s", expected, output)
+		}
+	}
+
+	buffer.Reset()
+	if err := m.write(&buffer, -1); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if strings.Contains(buffer.String(), "welcomebot_queue_depth") {
+		t.Error("expected the queue depth to be omitted")
+	}
+}
+
+func TestServeMetrics(t *testing.T) {
+	for name, tc := range map[string]struct {
+		token          string
+		url            string
+		authorization  string
+		expectedStatus int
+	}{
+		"token":                 {token: "secret", url: "/metrics?token=secret", expectedStatus: http.StatusOK},
+		"wrong token":           {token: "secret", url: "/metrics?token=other", expectedStatus: http.StatusUnauthorized},
+		"missing token":         {token: "secret", url: "/metrics", expectedStatus: http.StatusUnauthorized},
+		"no token configured":   {url: "/metrics?token=", expectedStatus: http.StatusUnauthorized},
+		"authorization header":  {token: "secret", url: "/metrics", authorization: "Bearer secret", expectedStatus: http.StatusUnauthorized},
+		"token in another path": {token: "secret", url: "/history?token=secret", expectedStatus: http.StatusUnauthorized},
+	} {
+		t.Run(name, func(t *testing.T) {
+			p := &Plugin{}
+			p.metricsToken.Store(tc.token)
+			p.metrics.incWelcomesSent(welcomeKindTeam)
+
+			r := httptest.NewRequest(http.MethodGet, tc.url, nil)
+			if tc.authorization != "" {
+				r.Header.Set("Authorization", tc.authorization)
+			}
+			w := httptest.NewRecorder()
+			p.ServeHTTP(nil, w, r)
+
+			if w.Code != tc.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", tc.expectedStatus, w.Code, w.Body.String())
+			}
+			if tc.expectedStatus == http.StatusOK && !strings.Contains(w.Body.String(), `welcomebot_welcomes_sent_total{kind="team"} 1`) {
+				t.Errorf("expected the metrics, got:\n%s", w.Body.String())
+			}
+		})
+	}
+}
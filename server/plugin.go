@@ -1,11 +1,13 @@
 package main
 
 import (
+	"sync"
 	"sync/atomic"
 
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
 	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
 	"github.com/pkg/errors"
 )
 
@@ -14,7 +16,12 @@ const (
 	botDisplayName = "Welcomebot"
 	botDescription = "A bot account created by the Welcomebot plugin."
 
+	// Channel welcome messages are listed by this prefix, which the keys of the other data of the
+	// channels must then not start with
 	welcomebotChannelWelcomeKey = "chanmsg_"
+
+	// Translations of the channel welcome messages
+	welcomebotChannelTranslationsKey = "chanloc_"
 )
 
 // Plugin represents the welcome bot plugin
@@ -23,7 +30,26 @@ type Plugin struct {
 
 	client *pluginapi.Client
 
-	welcomeMessages atomic.Value
+	// snapshot holds the compiled *configurationSnapshot of the welcome messages
+	snapshot atomic.Value
+
+	// snapshotLock serializes the rebuilds of the snapshot
+	snapshotLock sync.Mutex
+
+	// metricsToken holds the token granting access to the metrics, from the plugin settings
+	metricsToken atomic.Value
+
+	// metrics counts the deliveries handled by this node since the plugin started
+	metrics metrics
+
+	// scheduler delivers delayed welcome messages
+	scheduler *cluster.JobOnceScheduler
+
+	// backfillStop is closed when the plugin is deactivated, to stop the running backfills
+	backfillStop chan struct{}
+
+	// backfills tracks the backfills running on this node
+	backfills sync.WaitGroup
 
 	// botUserID of the created bot account.
 	botUserID string
@@ -43,11 +69,31 @@ func (p *Plugin) OnActivate() error {
 		return errors.Wrap(appErr, "failed to ensure bot user")
 	}
 	p.botUserID = botUserID
+	p.backfillStop = make(chan struct{})
 
 	err := p.API.RegisterCommand(getCommand())
 	if err != nil {
 		return errors.Wrap(err, "failed to register command")
 	}
 
+	p.scheduler = cluster.GetJobOnceScheduler(p.API)
+	if err := p.scheduler.SetCallback(p.handleWelcomeJob); err != nil {
+		return errors.Wrap(err, "failed to set scheduler callback")
+	}
+	// Starting the scheduler also picks up the jobs left pending by a previous run
+	if err := p.scheduler.Start(); err != nil {
+		return errors.Wrap(err, "failed to start scheduler")
+	}
+
+	return nil
+}
+
+// OnDeactivate stops the running backfills, and waits for them to release their lock
+func (p *Plugin) OnDeactivate() error {
+	if p.backfillStop != nil {
+		close(p.backfillStop)
+	}
+	p.backfills.Wait()
+
 	return nil
 }
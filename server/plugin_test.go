@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+)
+
+// fakeAPI implements the plugin API methods the tests need: teams and channels looked up by name,
+// an in-memory KV store and logs. Calling any other method panics.
+type fakeAPI struct {
+	plugin.API
+
+	teams    map[string]*model.Team
+	channels map[string]*model.Channel
+	kv       map[string][]byte
+	logs     []string
+}
+
+// newTestPlugin returns a plugin using the given fake API, with its client set up like OnActivate
+// does.
+func newTestPlugin(api *fakeAPI) *Plugin {
+	if api.kv == nil {
+		api.kv = make(map[string][]byte)
+	}
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.client = pluginapi.NewClient(api, nil)
+
+	return p
+}
+
+func (a *fakeAPI) GetTeamByName(name string) (*model.Team, *model.AppError) {
+	if team, ok := a.teams[name]; ok {
+		return team, nil
+	}
+	return nil, model.NewAppError("GetTeamByName", "not_found", nil, "", http.StatusNotFound)
+}
+
+func (a *fakeAPI) GetChannelByName(teamID, name string, _ bool) (*model.Channel, *model.AppError) {
+	if channel, ok := a.channels[name]; ok && channel.TeamId == teamID {
+		return channel, nil
+	}
+	return nil, model.NewAppError("GetChannelByName", "not_found", nil, "", http.StatusNotFound)
+}
+
+func (a *fakeAPI) GetUser(string) (*model.User, *model.AppError) {
+	return nil, model.NewAppError("GetUser", "not_found", nil, "", http.StatusNotFound)
+}
+
+func (a *fakeAPI) KVGet(key string) ([]byte, *model.AppError) {
+	return a.kv[key], nil
+}
+
+func (a *fakeAPI) KVSet(key string, value []byte) *model.AppError {
+	_, appErr := a.KVSetWithOptions(key, value, model.PluginKVSetOptions{})
+	return appErr
+}
+
+func (a *fakeAPI) KVSetWithOptions(key string, value []byte, options model.PluginKVSetOptions) (bool, *model.AppError) {
+	if options.Atomic && !bytes.Equal(a.kv[key], options.OldValue) {
+		return false, nil
+	}
+
+	if value == nil {
+		delete(a.kv, key)
+	} else {
+		a.kv[key] = value
+	}
+	return true, nil
+}
+
+func (a *fakeAPI) KVDelete(key string) *model.AppError {
+	delete(a.kv, key)
+	return nil
+}
+
+func (a *fakeAPI) KVList(page, perPage int) ([]string, *model.AppError) {
+	keys := make([]string, 0, len(a.kv))
+	for key := range a.kv {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	start, end := min(page*perPage, len(keys)), min((page+1)*perPage, len(keys))
+	return keys[start:end], nil
+}
+
+func (a *fakeAPI) PublishPluginClusterEvent(model.PluginClusterEvent, model.PluginClusterEventSendOptions) error {
+	return nil
+}
+
+func (a *fakeAPI) LogError(msg string, keyValuePairs ...interface{}) {
+	a.log("error", msg, keyValuePairs)
+}
+
+func (a *fakeAPI) LogWarn(msg string, keyValuePairs ...interface{}) {
+	a.log("warn", msg, keyValuePairs)
+}
+
+func (a *fakeAPI) LogInfo(msg string, keyValuePairs ...interface{}) {
+	a.log("info", msg, keyValuePairs)
+}
+
+func (a *fakeAPI) LogDebug(msg string, keyValuePairs ...interface{}) {
+	a.log("debug", msg, keyValuePairs)
+}
+
+func (a *fakeAPI) log(level, msg string, keyValuePairs []interface{}) {
+	a.logs = append(a.logs, fmt.Sprintf("%s: %s %v", level, msg, keyValuePairs))
+}
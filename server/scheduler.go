@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// WelcomeJob is a delayed welcome message delivery persisted in the KV store. Jobs survive
+// plugin restarts and are handled by a single node of the cluster.
+type WelcomeJob struct {
+	UserID    string
+	TeamID    string
+	MessageID string
+
+	// The channel of the welcome message, for the delayed channel welcome messages
+	ChannelID string `json:",omitempty"`
+
+	// The user who added the user to the team, if any
+	ActorID string
+
+	// Index of the step of the message to deliver
+	Step int
+
+	// Number of steps of the message when the job was queued. Indexes shift when the steps or the
+	// top level message change, so the job is dropped if the number differs.
+	StepCount int `json:",omitempty"`
+
+	// The variant of the message assigned to the user, if any
+	Variant string `json:",omitempty"`
+
+	// Time in milliseconds of the join the job was queued for
+	JoinedAt int64
+
+	// Time in milliseconds at which the job was queued
+	CreateAt int64
+
+	// Whether the automatic actions of the message are skipped
+	SkipAutomaticActions bool
+}
+
+// scheduleWelcomeMessage queues the delivery of every step of the given message. The job is used
+// as a template for the jobs of all the steps. Step delays are relative to the time of joining.
+func (p *Plugin) scheduleWelcomeMessage(template WelcomeJob, configMessage *ConfigMessage) error {
+	now := time.Now()
+	steps := configMessage.getSteps()
+	for i, step := range steps {
+		job := template
+		job.MessageID = configMessage.ID
+		job.Step = i
+		job.StepCount = len(steps)
+		job.CreateAt = model.GetMillis()
+
+		runAt := now.Add(time.Second * time.Duration(step.DelayInSeconds))
+		if _, err := p.scheduler.ScheduleOnce(model.NewId(), runAt, &job); err != nil {
+			return errors.Wrapf(err, "failed to schedule step %d of the welcome message", i)
+		}
+	}
+
+	return nil
+}
+
+// handleWelcomeJob is the scheduler callback, invoked once the job is due. It is also invoked for
+// jobs that became due while the plugin was not running.
+func (p *Plugin) handleWelcomeJob(key string, props any) {
+	job, err := decodeWelcomeJob(props)
+	if err != nil {
+		p.API.LogError("failed to decode scheduled welcome message", "job_key", key, "err", err.Error())
+		return
+	}
+
+	if job.ChannelID != "" {
+		p.handleChannelWelcomeJob(key, job)
+		return
+	}
+
+	configMessage := p.getWelcomeMessageByID(job.MessageID)
+	if configMessage == nil {
+		p.API.LogWarn("dropping scheduled welcome message that is no longer configured", "job_key", key, "message_id", job.MessageID)
+		return
+	}
+
+	variantMessage := configMessage.withVariant(job.Variant)
+	steps := variantMessage.getSteps()
+	if job.Step >= len(steps) || (job.StepCount > 0 && job.StepCount != len(steps)) {
+		p.API.LogWarn("dropping scheduled welcome message step that is no longer configured", "job_key", key, "message_id", job.MessageID, "step", job.Step, "step_count", job.StepCount)
+		return
+	}
+
+	data := p.constructMessageTemplate(job.UserID, job.TeamID)
+	if data == nil {
+		return
+	}
+	data.setActor(job.ActorID)
+
+	if err := p.claimDelivery(job); err != nil {
+		if err != errAlreadyDelivered && err != errStaleDelivery {
+			p.API.LogError("failed to claim welcome message delivery", "job_key", key, "message_id", job.MessageID, "err", err.Error())
+		}
+		return
+	}
+
+	stepMessage := variantMessage.forStep(steps[job.Step]).localized(p.getLocaleFallbacks(data.User))
+	if job.SkipAutomaticActions {
+		stepMessage = stepMessage.withoutAutomaticActions()
+	}
+
+	if err := p.processWelcomeMessage(*data, stepMessage); err != nil {
+		if err := p.releaseDelivery(job); err != nil {
+			p.API.LogError("failed to release welcome message delivery", "job_key", key, "message_id", job.MessageID, "err", err.Error())
+		}
+		return
+	}
+
+	dueAt := time.UnixMilli(job.JoinedAt).Add(time.Second * time.Duration(steps[job.Step].DelayInSeconds))
+	p.metrics.observeDeliveryLatency(max(time.Since(dueAt), 0))
+
+	firstVariantSend, err := p.completeDelivery(job)
+	if err != nil {
+		p.API.LogError("failed to record welcome message delivery", "job_key", key, "message_id", job.MessageID, "err", err.Error())
+	}
+
+	// Resends and rejoins post the variant again, but it is counted once per user for the
+	// click-through rate
+	if firstVariantSend && variantMessage.Variant != "" {
+		p.updateVariantStats(job.MessageID, job.Variant, func(stats *VariantStats) {
+			stats.Sent++
+		})
+	}
+}
+
+// decodeWelcomeJob converts the props of a job into a WelcomeJob. Props of jobs loaded back from
+// the KV store are generic maps, so they go through a JSON round trip.
+func decodeWelcomeJob(props any) (*WelcomeJob, error) {
+	data, err := json.Marshal(props)
+	if err != nil {
+		return nil, err
+	}
+
+	var job WelcomeJob
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
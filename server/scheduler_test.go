@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHandleWelcomeJobStepCount(t *testing.T) {
+	message := &ConfigMessage{
+		ID:       "message-id",
+		TeamName: "team",
+		Message:  []string{"Hi"},
+		Steps:    []*ConfigMessageStep{{Message: []string{"How is it going?"}, DelayInSeconds: 60}},
+		Variants: []*ConfigMessageVariant{{Name: "short", Message: []string{"Hey"}}},
+	}
+
+	for name, tc := range map[string]struct {
+		job     *WelcomeJob
+		dropped bool
+	}{
+		"same step count":       {job: &WelcomeJob{Step: 1, StepCount: 2}},
+		"unknown step count":    {job: &WelcomeJob{Step: 1}},
+		"variant":               {job: &WelcomeJob{Step: 0, StepCount: 2, Variant: "short"}},
+		"step removed":          {job: &WelcomeJob{Step: 1, StepCount: 3}, dropped: true},
+		"step added":            {job: &WelcomeJob{Step: 0, StepCount: 1}, dropped: true},
+		"step beyond the steps": {job: &WelcomeJob{Step: 2}, dropped: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			api := &fakeAPI{}
+			p := newTestPlugin(api)
+			p.snapshot.Store(p.newConfigurationSnapshot([]*ConfigMessage{message}, nil))
+
+			tc.job.UserID, tc.job.TeamID, tc.job.MessageID = "user-id", "team-id", message.ID
+			p.handleWelcomeJob("job-key", tc.job)
+
+			// Jobs which are not dropped go on with loading the user, which the fake API doesn't know
+			expected := "failed to query user"
+			if tc.dropped {
+				expected = "dropping scheduled welcome message step"
+			}
+			if len(api.logs) == 0 || !strings.Contains(api.logs[0], expected) {
+				t.Errorf("expected dropped %v, got logs %q", tc.dropped, api.logs)
+			}
+			if len(api.kv) > 0 {
+				t.Errorf("expected no delivery to be claimed, got %v", api.kv)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"strings"
+	"text/template"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+)
+
+// Cluster event sent when the stored welcome messages change, so that every node rebuilds its
+// snapshot
+const clusterEventTeamWelcomesChanged = "team_welcomes_changed"
+
+// configurationSnapshot is the compiled form of the welcome messages. It is built once whenever the
+// configuration or the stored messages change, and never modified afterwards.
+type configurationSnapshot struct {
+	// Messages defined in config.json
+	configMessages []*ConfigMessage
+
+	// Messages managed from inside Mattermost
+	storedMessages []*ConfigMessage
+
+	// All the messages, the ones from config.json first
+	messages []*ConfigMessage
+
+	messagesByID   map[string]*ConfigMessage
+	messagesByTeam map[string][]*ConfigMessage
+
+	// Actions by team name and action name, as matched by /addchannels
+	actions map[actionKey]*ConfigMessageAction
+
+	// Parsed templates. A nil template failed to parse, which has been reported when loading.
+	templates map[templateKey]*template.Template
+}
+
+type actionKey struct {
+	teamName   string
+	actionName string
+}
+
+type templateKey struct {
+	name string
+	text string
+}
+
+// newConfigurationSnapshot compiles the given messages. Templates which fail to parse are logged
+// once here, and rendered as empty messages.
+func (p *Plugin) newConfigurationSnapshot(configMessages, storedMessages []*ConfigMessage) *configurationSnapshot {
+	s := &configurationSnapshot{
+		configMessages: configMessages,
+		storedMessages: storedMessages,
+		messages:       append(append([]*ConfigMessage{}, configMessages...), storedMessages...),
+		messagesByID:   make(map[string]*ConfigMessage),
+		messagesByTeam: make(map[string][]*ConfigMessage),
+		actions:        make(map[actionKey]*ConfigMessageAction),
+		templates:      make(map[templateKey]*template.Template),
+	}
+
+	for _, message := range s.messages {
+		if _, ok := s.messagesByID[message.ID]; !ok {
+			s.messagesByID[message.ID] = message
+		}
+		s.messagesByTeam[message.TeamName] = append(s.messagesByTeam[message.TeamName], message)
+
+		// The actions of the variants come last, so that the actions of the top level message are
+		// matched first by the clicks without a variant
+		for _, step := range append(message.getSteps(), message.getVariantSteps()...) {
+			if step == nil {
+				continue
+			}
+			p.compileTemplate(s, message, "Response", step.Message)
+			p.compileTemplate(s, message, "AttachmentResponse", step.AttachmentMessage)
+			for _, translation := range step.Translations {
+				if translation != nil {
+					p.compileTemplate(s, message, "Response", translation.Message)
+					p.compileTemplate(s, message, "AttachmentResponse", translation.AttachmentMessage)
+				}
+			}
+
+			for _, action := range step.Actions {
+				if action == nil {
+					continue
+				}
+				p.compileTemplate(s, message, "Response", action.ActionSuccessfulMessage)
+				for _, translation := range action.Translations {
+					if translation != nil {
+						p.compileTemplate(s, message, "Response", translation.ActionSuccessfulMessage)
+					}
+				}
+
+				key := actionKey{teamName: message.TeamName, actionName: action.ActionName}
+				if _, ok := s.actions[key]; !ok {
+					s.actions[key] = action
+				}
+			}
+		}
+	}
+
+	return s
+}
+
+func (p *Plugin) compileTemplate(s *configurationSnapshot, message *ConfigMessage, name string, lines []string) {
+	key := templateKey{name: name, text: strings.Join(lines, "\n")}
+	if _, ok := s.templates[key]; ok {
+		return
+	}
+
+	tmpl, err := p.parseTemplate(name, lines)
+	if err != nil {
+		p.API.LogError("Failed to parse message template", "message_id", message.ID, "err", err.Error())
+	}
+	s.templates[key] = tmpl
+}
+
+// getSnapshot returns the current snapshot of the welcome messages
+func (p *Plugin) getSnapshot() *configurationSnapshot {
+	if s, ok := p.snapshot.Load().(*configurationSnapshot); ok {
+		return s
+	}
+
+	return &configurationSnapshot{}
+}
+
+// getTemplate returns the parsed template with the given lines. Templates of the snapshot are
+// parsed once, other ones, e.g. for previews of unsaved messages, are parsed on every call.
+func (p *Plugin) getTemplate(name string, lines []string) (*template.Template, error) {
+	if tmpl, ok := p.getSnapshot().templates[templateKey{name: name, text: strings.Join(lines, "\n")}]; ok {
+		return tmpl, nil
+	}
+
+	return p.parseTemplate(name, lines)
+}
+
+// reloadStoredWelcomeMessages rebuilds the snapshot after the stored messages changed. Other
+// nodes of the cluster are notified when publish is set.
+func (p *Plugin) reloadStoredWelcomeMessages(publish bool) {
+	p.snapshotLock.Lock()
+	defer p.snapshotLock.Unlock()
+
+	stored, err := p.getStoredWelcomeMessages()
+	if err != nil {
+		p.API.LogError("failed to reload stored welcome messages", "err", err.Error())
+		return
+	}
+	p.snapshot.Store(p.newConfigurationSnapshot(p.getSnapshot().configMessages, stored))
+
+	if !publish {
+		return
+	}
+	event := model.PluginClusterEvent{Id: clusterEventTeamWelcomesChanged}
+	if err := p.API.PublishPluginClusterEvent(event, model.PluginClusterEventSendOptions{SendType: model.PluginClusterEventSendTypeReliable}); err != nil {
+		p.API.LogError("failed to notify the cluster of the welcome messages change", "err", err.Error())
+	}
+}
+
+// OnPluginClusterEvent is invoked when another node of the cluster publishes an event
+func (p *Plugin) OnPluginClusterEvent(_ *plugin.Context, ev model.PluginClusterEvent) {
+	if ev.Id == clusterEventTeamWelcomesChanged {
+		p.reloadStoredWelcomeMessages(false)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+func TestConfigurationSnapshotIndexes(t *testing.T) {
+	configAction := &ConfigMessageAction{ActionType: actionTypeButton, ActionName: "join"}
+	stepAction := &ConfigMessageAction{ActionType: actionTypeButton, ActionName: "help"}
+	variantAction := &ConfigMessageAction{ActionType: actionTypeButton, ActionName: "join"}
+	storedAction := &ConfigMessageAction{ActionType: actionTypeButton, ActionName: "join"}
+
+	config := &ConfigMessage{
+		ID:       "config",
+		TeamName: "team",
+		Message:  []string{"Hi"},
+		Actions:  []*ConfigMessageAction{configAction},
+		Steps:    []*ConfigMessageStep{nil, {Message: []string{"Later"}, Actions: []*ConfigMessageAction{nil, stepAction}}},
+		Variants: []*ConfigMessageVariant{nil, {Name: "short", Message: []string{"Hey"}, Actions: []*ConfigMessageAction{variantAction}}},
+	}
+	duplicate := &ConfigMessage{ID: "config", TeamName: "other", Message: []string{"Hello"}}
+	stored := &ConfigMessage{ID: "stored", TeamName: "other", Message: []string{"Hello"}, Actions: []*ConfigMessageAction{storedAction}}
+
+	p := newTestPlugin(&fakeAPI{})
+	s := p.newConfigurationSnapshot([]*ConfigMessage{config, duplicate}, []*ConfigMessage{stored})
+
+	if len(s.messages) != 3 || s.messages[0] != config || s.messages[2] != stored {
+		t.Errorf("expected the messages of config.json first, got %v", s.messages)
+	}
+
+	// The first message with an ID wins
+	if s.messagesByID["config"] != config || s.messagesByID["stored"] != stored || len(s.messagesByID) != 2 {
+		t.Errorf("unexpected messages by ID %v", s.messagesByID)
+	}
+
+	if teamMessages := s.messagesByTeam["team"]; len(teamMessages) != 1 || teamMessages[0] != config {
+		t.Errorf("unexpected messages of team %v", teamMessages)
+	}
+	if otherMessages := s.messagesByTeam["other"]; len(otherMessages) != 2 || otherMessages[0] != duplicate || otherMessages[1] != stored {
+		t.Errorf("unexpected messages of other %v", otherMessages)
+	}
+
+	// The actions of the top level message come before the ones of the variants
+	for key, expected := range map[actionKey]*ConfigMessageAction{
+		{teamName: "team", actionName: "join"}:  configAction,
+		{teamName: "team", actionName: "help"}:  stepAction,
+		{teamName: "other", actionName: "join"}: storedAction,
+	} {
+		if s.actions[key] != expected {
+			t.Errorf("expected action %+v for %+v, got %+v", expected, key, s.actions[key])
+		}
+	}
+	if len(s.actions) != 3 {
+		t.Errorf("expected 3 actions, got %v", s.actions)
+	}
+}
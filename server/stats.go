@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	welcomebotStatsKey = "stats_"
+
+	flagSince = "--since"
+
+	statsDateLayout = "2006-01-02"
+
+	// Statistics are reported for the last days by default, and for a year at most
+	defaultStatsDays = 30
+	maxStatsDays     = 366
+
+	statsFormatJSON = "json"
+	statsFormatCSV  = "csv"
+)
+
+// OnboardingStats are the counters of the welcomes of a team
+type OnboardingStats struct {
+	WelcomesSent          int64                   `json:"welcomes_sent"`
+	WelcomesFailed        int64                   `json:"welcomes_failed"`
+	ChannelWelcomesSent   int64                   `json:"channel_welcomes_sent"`
+	ChannelWelcomesFailed int64                   `json:"channel_welcomes_failed"`
+	Actions               map[string]*ActionStats `json:"actions"`
+}
+
+// ActionStats are the counters of the clicks on the buttons of an action
+type ActionStats struct {
+	Clicks int64 `json:"clicks"`
+
+	// Clicks for which the time the user joined the team is known, and the sum of the seconds
+	// between the join and the click
+	TimedClicks         int64 `json:"timed_clicks"`
+	TotalSecondsToClick int64 `json:"total_seconds_to_click"`
+}
+
+// AverageSecondsToClick is the average time between joining the team and clicking, or 0 if unknown
+func (s *ActionStats) AverageSecondsToClick() int64 {
+	if s.TimedClicks == 0 {
+		return 0
+	}
+
+	return s.TotalSecondsToClick / s.TimedClicks
+}
+
+// add adds the counters of other to the ones of s
+func (s *OnboardingStats) add(other *OnboardingStats) {
+	s.WelcomesSent += other.WelcomesSent
+	s.WelcomesFailed += other.WelcomesFailed
+	s.ChannelWelcomesSent += other.ChannelWelcomesSent
+	s.ChannelWelcomesFailed += other.ChannelWelcomesFailed
+	for name, action := range other.Actions {
+		if s.Actions == nil {
+			s.Actions = make(map[string]*ActionStats)
+		}
+		if s.Actions[name] == nil {
+			s.Actions[name] = &ActionStats{}
+		}
+		s.Actions[name].Clicks += action.Clicks
+		s.Actions[name].TimedClicks += action.TimedClicks
+		s.Actions[name].TotalSecondsToClick += action.TotalSecondsToClick
+	}
+}
+
+// The counters of a team for a day are stored in their own key, so that the teams don't contend
+// for the same key
+func getStatsKey(day time.Time, teamID string) string {
+	return welcomebotStatsKey + day.UTC().Format(statsDateLayout) + "_" + teamID
+}
+
+// parseStatsKey returns the day and the team of the counters stored in the given key. ok is false
+// if the key doesn't hold the counters of a team.
+func parseStatsKey(key string) (date, teamID string, ok bool) {
+	date, teamID, ok = strings.Cut(strings.TrimPrefix(key, welcomebotStatsKey), "_")
+	if !ok || len(date) != len(statsDateLayout) || teamID == "" {
+		return "", "", false
+	}
+
+	return date, teamID, true
+}
+
+// updateStats atomically updates the counters of the team for the current day. The counters are
+// left unchanged on errors, which are logged.
+func (p *Plugin) updateStats(teamID string, update func(stats *OnboardingStats)) {
+	err := p.client.KV.SetAtomicWithRetries(getStatsKey(time.Now(), teamID), func(oldValue []byte) (interface{}, error) {
+		stats := &OnboardingStats{}
+		if len(oldValue) > 0 {
+			if err := json.Unmarshal(oldValue, stats); err != nil {
+				return nil, err
+			}
+		}
+
+		if stats.Actions == nil {
+			stats.Actions = make(map[string]*ActionStats)
+		}
+		update(stats)
+		return stats, nil
+	})
+	if err != nil {
+		p.API.LogError("failed to update the onboarding statistics", "team_id", teamID, "err", err.Error())
+	}
+}
+
+// recordActionClick counts a click on a button of an action. The time to click is measured from
+// the join of the team recorded in the ledger of the message, when known.
+func (p *Plugin) recordActionClick(userID, teamID, messageID, actionName string) {
+	var joinedAt int64
+	if messageID != "" {
+		record, err := p.getDeliveryRecord(userID, teamID, messageID)
+		if err != nil {
+			p.API.LogError("failed to get the delivery record of the clicked message", "user_id", userID, "message_id", messageID, "err", err.Error())
+		} else {
+			joinedAt = record.JoinedAt
+		}
+	}
+
+	p.updateStats(teamID, func(stats *OnboardingStats) {
+		if stats.Actions[actionName] == nil {
+			stats.Actions[actionName] = &ActionStats{}
+		}
+		action := stats.Actions[actionName]
+		action.Clicks++
+		if joinedAt > 0 {
+			action.TimedClicks++
+			action.TotalSecondsToClick += (model.GetMillis() - joinedAt) / 1000
+		}
+	})
+}
+
+// StatsDay are the counters of a team for a day
+type StatsDay struct {
+	Date string `json:"date"`
+	*OnboardingStats
+}
+
+// TeamStatsReport are the counters of a team over a period
+type TeamStatsReport struct {
+	TeamID   string           `json:"team_id"`
+	TeamName string           `json:"team_name"`
+	Total    *OnboardingStats `json:"total"`
+	Days     []*StatsDay      `json:"days"`
+}
+
+// StatsReport are the counters of the teams over a period, oldest day first
+type StatsReport struct {
+	Since string             `json:"since"`
+	Until string             `json:"until"`
+	Teams []*TeamStatsReport `json:"teams"`
+}
+
+// parseStatsSince parses the first day to report, which defaults to defaultStatsDays ago
+func parseStatsSince(value string) (time.Time, error) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	if value == "" {
+		return today.AddDate(0, 0, -defaultStatsDays+1), nil
+	}
+
+	since, err := time.Parse(statsDateLayout, value)
+	if err != nil {
+		return time.Time{}, errors.Errorf("invalid date `%s`, expected YYYY-MM-DD", value)
+	}
+	if since.After(today) {
+		return time.Time{}, errors.New("the date must not be in the future")
+	}
+	if today.Sub(since) >= maxStatsDays*24*time.Hour {
+		return time.Time{}, errors.Errorf("statistics can be reported for %d days at most", maxStatsDays)
+	}
+
+	return since, nil
+}
+
+// getStatsReport collects the counters since the given day. Only the given team is reported if
+// set, otherwise all the teams with counters are.
+func (p *Plugin) getStatsReport(team *model.Team, since time.Time) (*StatsReport, error) {
+	today := time.Now().UTC()
+	report := &StatsReport{
+		Since: since.Format(statsDateLayout),
+		Until: today.Format(statsDateLayout),
+		Teams: []*TeamStatsReport{},
+	}
+
+	// The teams with counters for each day
+	teamIDsByDate := make(map[string][]string)
+	if team == nil {
+		keys, err := p.listKeysWithPrefix(welcomebotStatsKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list the statistics")
+		}
+		for _, key := range keys {
+			if date, teamID, ok := parseStatsKey(key); ok {
+				teamIDsByDate[date] = append(teamIDsByDate[date], teamID)
+			}
+		}
+	}
+
+	teams := make(map[string]*TeamStatsReport)
+	for day := since; day.Format(statsDateLayout) <= report.Until; day = day.AddDate(0, 0, 1) {
+		date := day.Format(statsDateLayout)
+		teamIDs := teamIDsByDate[date]
+		if team != nil {
+			teamIDs = []string{team.Id}
+		}
+		for _, teamID := range teamIDs {
+			var teamStats *OnboardingStats
+			if err := p.client.KV.Get(getStatsKey(day, teamID), &teamStats); err != nil {
+				return nil, errors.Wrapf(err, "failed to get the statistics of team %s for %s", teamID, date)
+			}
+			if teamStats == nil {
+				continue
+			}
+			if teams[teamID] == nil {
+				teams[teamID] = &TeamStatsReport{TeamID: teamID, Total: &OnboardingStats{}}
+			}
+			teams[teamID].Total.add(teamStats)
+			teams[teamID].Days = append(teams[teamID].Days, &StatsDay{Date: date, OnboardingStats: teamStats})
+		}
+	}
+
+	for _, teamReport := range teams {
+		teamReport.TeamName = teamReport.TeamID
+		if t, appErr := p.API.GetTeam(teamReport.TeamID); appErr == nil {
+			teamReport.TeamName = t.Name
+		}
+		report.Teams = append(report.Teams, teamReport)
+	}
+	sort.Slice(report.Teams, func(i, j int) bool {
+		return report.Teams[i].TeamName < report.Teams[j].TeamName
+	})
+
+	return report, nil
+}
+
+// formatStatsReport renders the totals of the report as Markdown tables
+func formatStatsReport(report *StatsReport) string {
+	var str strings.Builder
+	str.WriteString(fmt.Sprintf("Onboarding statistics from %s to %s (UTC):\n", report.Since, report.Until))
+	if len(report.Teams) == 0 {
+		str.WriteString("\nNo welcome message has been sent in this period.")
+		return str.String()
+	}
+
+	str.WriteString("\n| Team | Welcomes sent | Welcomes failed | Channel welcomes sent | Channel welcomes failed |\n|---|---|---|---|---|\n")
+	for _, team := range report.Teams {
+		str.WriteString(fmt.Sprintf("| %s | %d | %d | %d | %d |\n",
+			team.TeamName, team.Total.WelcomesSent, team.Total.WelcomesFailed, team.Total.ChannelWelcomesSent, team.Total.ChannelWelcomesFailed))
+	}
+
+	var actions strings.Builder
+	for _, team := range report.Teams {
+		for _, name := range sortedActionNames(team.Total) {
+			action := team.Total.Actions[name]
+			averageTime := "-"
+			if action.TimedClicks > 0 {
+				averageTime = (time.Duration(action.AverageSecondsToClick()) * time.Second).String()
+			}
+			actions.WriteString(fmt.Sprintf("| %s | `%s` | %d | %s |\n", team.TeamName, name, action.Clicks, averageTime))
+		}
+	}
+	if actions.Len() > 0 {
+		str.WriteString("\n| Team | Action | Clicks | Average time from joining to clicking |\n|---|---|---|---|\n")
+		str.WriteString(actions.String())
+	}
+
+	return str.String()
+}
+
+func sortedActionNames(stats *OnboardingStats) []string {
+	names := make([]string, 0, len(stats.Actions))
+	for name := range stats.Actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// writeStatsCSV writes the counters of every team and day of the report, one counter per row
+func writeStatsCSV(w io.Writer, report *StatsReport) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"date", "team", "metric", "action", "value"}); err != nil {
+		return err
+	}
+
+	for _, team := range report.Teams {
+		for _, day := range team.Days {
+			rows := [][]string{
+				{day.Date, team.TeamName, "welcomes_sent", "", strconv.FormatInt(day.WelcomesSent, 10)},
+				{day.Date, team.TeamName, "welcomes_failed", "", strconv.FormatInt(day.WelcomesFailed, 10)},
+				{day.Date, team.TeamName, "channel_welcomes_sent", "", strconv.FormatInt(day.ChannelWelcomesSent, 10)},
+				{day.Date, team.TeamName, "channel_welcomes_failed", "", strconv.FormatInt(day.ChannelWelcomesFailed, 10)},
+			}
+			for _, name := range sortedActionNames(day.OnboardingStats) {
+				action := day.Actions[name]
+				rows = append(rows,
+					[]string{day.Date, team.TeamName, "action_clicks", name, strconv.FormatInt(action.Clicks, 10)},
+					[]string{day.Date, team.TeamName, "action_average_seconds_to_click", name, strconv.FormatInt(action.AverageSecondsToClick(), 10)},
+				)
+			}
+			if err := writer.WriteAll(rows); err != nil {
+				return err
+			}
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
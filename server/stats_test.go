@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestOnboardingStatsAdd(t *testing.T) {
+	total := &OnboardingStats{}
+	total.add(&OnboardingStats{
+		WelcomesSent: 2,
+		Actions:      map[string]*ActionStats{"engineering": {Clicks: 1, TimedClicks: 1, TotalSecondsToClick: 60}},
+	})
+	total.add(&OnboardingStats{
+		WelcomesSent:        1,
+		WelcomesFailed:      1,
+		ChannelWelcomesSent: 3,
+		Actions:             map[string]*ActionStats{"engineering": {Clicks: 2, TimedClicks: 1, TotalSecondsToClick: 180}},
+	})
+
+	if total.WelcomesSent != 3 || total.WelcomesFailed != 1 || total.ChannelWelcomesSent != 3 {
+		t.Errorf("unexpected totals %+v", total)
+	}
+	action := total.Actions["engineering"]
+	if action.Clicks != 3 {
+		t.Errorf("expected 3 clicks, got %d", action.Clicks)
+	}
+	if average := action.AverageSecondsToClick(); average != 120 {
+		t.Errorf("expected an average of 120 seconds, got %d", average)
+	}
+}
+
+func TestParseStatsSince(t *testing.T) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	for _, tc := range []struct {
+		name     string
+		value    string
+		expected time.Time
+		err      bool
+	}{
+		{name: "default", value: "", expected: today.AddDate(0, 0, -defaultStatsDays+1)},
+		{name: "today", value: today.Format(statsDateLayout), expected: today},
+		{name: "invalid", value: "yesterday", err: true},
+		{name: "future", value: today.AddDate(0, 0, 1).Format(statsDateLayout), err: true},
+		{name: "too old", value: today.AddDate(-2, 0, 0).Format(statsDateLayout), err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			since, err := parseStatsSince(tc.value)
+			if tc.err {
+				if err == nil {
+					t.Errorf("expected an error, got %s", since)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !since.Equal(tc.expected) {
+				t.Errorf("expected %s, got %s", tc.expected, since)
+			}
+		})
+	}
+}
+
+func TestWriteStatsCSV(t *testing.T) {
+	report := &StatsReport{
+		Teams: []*TeamStatsReport{{
+			TeamName: "staff",
+			Days: []*StatsDay{{
+				Date: "2024-01-02",
+				OnboardingStats: &OnboardingStats{
+					WelcomesSent: 4,
+					Actions:      map[string]*ActionStats{"engineering": {Clicks: 2, TimedClicks: 2, TotalSecondsToClick: 30}},
+				},
+			}},
+		}},
+	}
+
+	var buffer bytes.Buffer
+	if err := writeStatsCSV(&buffer, report); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := `date,team,metric,action,value
+2024-01-02,staff,welcomes_sent,,4
+2024-01-02,staff,welcomes_failed,,0
+2024-01-02,staff,channel_welcomes_sent,,0
+2024-01-02,staff,channel_welcomes_failed,,0
+2024-01-02,staff,action_clicks,engineering,2
+2024-01-02,staff,action_average_seconds_to_click,engineering,15
+`
+	if buffer.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buffer.String())
+	}
+}
+
+func TestParseStatsKey(t *testing.T) {
+	day := time.Date(2024, 3, 5, 12, 0, 0, 0, time.UTC)
+
+	for name, tc := range map[string]struct {
+		key            string
+		expectedDate   string
+		expectedTeamID string
+		expectedOK     bool
+	}{
+		"team":  {key: getStatsKey(day, "team-id"), expectedDate: "2024-03-05", expectedTeamID: "team-id", expectedOK: true},
+		"other": {key: welcomebotStatsKey + "something"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			date, teamID, ok := parseStatsKey(tc.key)
+			if date != tc.expectedDate || teamID != tc.expectedTeamID || ok != tc.expectedOK {
+				t.Errorf("expected %q, %q, %v, got %q, %q, %v", tc.expectedDate, tc.expectedTeamID, tc.expectedOK, date, teamID, ok)
+			}
+		})
+	}
+}
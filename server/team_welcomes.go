@@ -0,0 +1,472 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	welcomebotTeamWelcomesKey = "team_welcomes"
+
+	teamWelcomeDialogCallbackID = "team_welcome"
+	dialogTextareaMaxLength     = 3000
+)
+
+// getStoredWelcomeMessages lists the team welcome messages managed from inside Mattermost.
+func (p *Plugin) getStoredWelcomeMessages() ([]*ConfigMessage, error) {
+	// The API is used rather than the client, as the messages are loaded by OnConfigurationChange,
+	// which runs before OnActivate creates the client
+	data, appErr := p.API.KVGet(welcomebotTeamWelcomesKey)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get stored welcome messages")
+	}
+
+	var messages []*ConfigMessage
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return nil, errors.Wrap(err, "failed to decode stored welcome messages")
+		}
+	}
+
+	for _, message := range messages {
+		message.Source = messageSourceStore
+	}
+
+	return messages, nil
+}
+
+// getStoredWelcomeMessage finds a team welcome message managed from inside Mattermost by its ID.
+func (p *Plugin) getStoredWelcomeMessage(id string) (*ConfigMessage, error) {
+	messages, err := p.getStoredWelcomeMessages()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, message := range messages {
+		if message.ID == id {
+			return message, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// saveStoredWelcomeMessage creates the given message, or replaces the stored message with the
+// same ID.
+func (p *Plugin) saveStoredWelcomeMessage(message *ConfigMessage) error {
+	defer p.reloadStoredWelcomeMessages(true)
+
+	return p.client.KV.SetAtomicWithRetries(welcomebotTeamWelcomesKey, func(oldValue []byte) (interface{}, error) {
+		var messages []*ConfigMessage
+		if len(oldValue) > 0 {
+			if err := json.Unmarshal(oldValue, &messages); err != nil {
+				return nil, err
+			}
+		}
+
+		for i, stored := range messages {
+			if stored.ID == message.ID {
+				messages[i] = message
+				return messages, nil
+			}
+		}
+
+		return append(messages, message), nil
+	})
+}
+
+// replaceStoredWelcomeMessages replaces all the stored messages with the given ones.
+func (p *Plugin) replaceStoredWelcomeMessages(messages []*ConfigMessage) error {
+	if messages == nil {
+		messages = []*ConfigMessage{}
+	}
+
+	if _, err := p.client.KV.Set(welcomebotTeamWelcomesKey, messages); err != nil {
+		return errors.Wrap(err, "failed to replace stored welcome messages")
+	}
+	p.reloadStoredWelcomeMessages(true)
+
+	return nil
+}
+
+// deleteStoredWelcomeMessage deletes a stored message and tells whether it existed.
+func (p *Plugin) deleteStoredWelcomeMessage(id string) (bool, error) {
+	defer p.reloadStoredWelcomeMessages(true)
+
+	found := false
+	err := p.client.KV.SetAtomicWithRetries(welcomebotTeamWelcomesKey, func(oldValue []byte) (interface{}, error) {
+		var messages []*ConfigMessage
+		if len(oldValue) > 0 {
+			if err := json.Unmarshal(oldValue, &messages); err != nil {
+				return nil, err
+			}
+		}
+
+		found = false
+		remaining := make([]*ConfigMessage, 0, len(messages))
+		for _, stored := range messages {
+			if stored.ID == id {
+				found = true
+				continue
+			}
+			remaining = append(remaining, stored)
+		}
+
+		return remaining, nil
+	})
+
+	return found, err
+}
+
+// canManageTeamWelcome tells whether the user is a system admin or an admin of the given team.
+func (p *Plugin) canManageTeamWelcome(userID, teamName string) bool {
+	if isSysadmin, err := p.hasSysadminRole(userID); err == nil && isSysadmin {
+		return true
+	}
+
+	team, appErr := p.API.GetTeamByName(strings.ToLower(teamName))
+	if appErr != nil {
+		return false
+	}
+
+	return p.API.HasPermissionToTeam(userID, team.Id, model.PermissionManageTeam)
+}
+
+func getTeamWelcomeDialog(message *ConfigMessage) model.Dialog {
+	title := "Create team welcome message"
+	if message.ID != "" {
+		title = "Edit team welcome message"
+	}
+
+	var actions, translations, steps, variants, conditions string
+	if len(message.Actions) > 0 {
+		data, _ := json.MarshalIndent(message.Actions, "", "  ")
+		actions = string(data)
+	}
+	if len(message.Translations) > 0 {
+		data, _ := json.MarshalIndent(message.Translations, "", "  ")
+		translations = string(data)
+	}
+	if len(message.Steps) > 0 {
+		data, _ := json.MarshalIndent(message.Steps, "", "  ")
+		steps = string(data)
+	}
+	if len(message.Variants) > 0 {
+		data, _ := json.MarshalIndent(message.Variants, "", "  ")
+		variants = string(data)
+	}
+	if message.Conditions != nil {
+		data, _ := json.MarshalIndent(message.Conditions, "", "  ")
+		conditions = string(data)
+	}
+
+	rejoinPolicy := message.RejoinPolicy
+	if rejoinPolicy == "" {
+		rejoinPolicy = rejoinPolicyAlways
+	}
+
+	return model.Dialog{
+		CallbackId:  teamWelcomeDialogCallbackID,
+		Title:       title,
+		SubmitLabel: "Save",
+		State:       message.ID,
+		Elements: []model.DialogElement{{
+			DisplayName: "Team name",
+			Name:        "team_name",
+			Type:        "text",
+			Default:     message.TeamName,
+			HelpText:    "The team handle used in the URL, in lowercase.",
+		}, {
+			DisplayName: "Message",
+			Name:        "message",
+			Type:        "textarea",
+			Default:     strings.Join(message.Message, "\n"),
+			Optional:    true,
+			MaxLength:   dialogTextareaMaxLength,
+			HelpText:    "The message posted to the user. It can use the fields of the message template, e.g. {{.UserDisplayName}}.",
+		}, {
+			DisplayName: "Attachment message",
+			Name:        "attachment_message",
+			Type:        "textarea",
+			Default:     strings.Join(message.AttachmentMessage, "\n"),
+			Optional:    true,
+			MaxLength:   dialogTextareaMaxLength,
+			HelpText:    "Message text in the attachment containing the action buttons.",
+		}, {
+			DisplayName: "Delay in seconds",
+			Name:        "delay_in_seconds",
+			Type:        "text",
+			SubType:     "number",
+			Default:     strconv.Itoa(message.DelayInSeconds),
+			Optional:    true,
+		}, {
+			DisplayName: "Include guests",
+			Name:        "include_guests",
+			Type:        "bool",
+			Default:     strconv.FormatBool(message.IncludeGuests),
+			Optional:    true,
+		}, {
+			DisplayName: "Rejoin policy",
+			Name:        "rejoin_policy",
+			Type:        "select",
+			Default:     rejoinPolicy,
+			Options: []*model.PostActionOptions{
+				{Text: "Always", Value: rejoinPolicyAlways},
+				{Text: "Never", Value: rejoinPolicyNever},
+				{Text: "After some days", Value: rejoinPolicyAfterDays},
+			},
+		}, {
+			DisplayName: "Rejoin after days",
+			Name:        "rejoin_after_days",
+			Type:        "text",
+			SubType:     "number",
+			Default:     strconv.Itoa(message.RejoinAfterDays),
+			Optional:    true,
+			HelpText:    "With the \"After some days\" policy, the number of days after which rejoining users receive the message again.",
+		}, {
+			DisplayName: "Actions",
+			Name:        "actions",
+			Type:        "textarea",
+			Default:     actions,
+			Optional:    true,
+			MaxLength:   dialogTextareaMaxLength,
+			HelpText:    "A JSON list of actions, in the format of the Actions of the plugin configuration.",
+		}, {
+			DisplayName: "Translations",
+			Name:        "translations",
+			Type:        "textarea",
+			Default:     translations,
+			Optional:    true,
+			MaxLength:   dialogTextareaMaxLength,
+			HelpText:    "A JSON object of the translations of the message by locale, in the format of the Translations of the plugin configuration.",
+		}, {
+			DisplayName: "Steps",
+			Name:        "steps",
+			Type:        "textarea",
+			Default:     steps,
+			Optional:    true,
+			MaxLength:   dialogTextareaMaxLength,
+			HelpText:    "A JSON list of follow-up steps, in the format of the Steps of the plugin configuration.",
+		}, {
+			DisplayName: "Variants",
+			Name:        "variants",
+			Type:        "textarea",
+			Default:     variants,
+			Optional:    true,
+			MaxLength:   dialogTextareaMaxLength,
+			HelpText:    "A JSON list of weighted variants of the message, in the format of the Variants of the plugin configuration.",
+		}, {
+			DisplayName: "Conditions",
+			Name:        "conditions",
+			Type:        "textarea",
+			Default:     conditions,
+			Optional:    true,
+			MaxLength:   dialogTextareaMaxLength,
+			HelpText:    "A JSON object restricting the message to some users, in the format of the Conditions of the plugin configuration.",
+		}},
+	}
+}
+
+func (p *Plugin) openTeamWelcomeDialog(triggerID string, message *ConfigMessage) error {
+	request := model.OpenDialogRequest{
+		TriggerId: triggerID,
+		URL:       fmt.Sprintf("/plugins/%s/dialog/team_welcome", manifest.Id),
+		Dialog:    getTeamWelcomeDialog(message),
+	}
+	if appErr := p.API.OpenInteractiveDialog(request); appErr != nil {
+		return errors.Wrap(appErr, "failed to open dialog")
+	}
+
+	return nil
+}
+
+// getSubmissionString reads a value of a dialog submission, whatever its JSON type.
+func getSubmissionString(submission map[string]any, name string) string {
+	value, ok := submission[name]
+	if !ok || value == nil {
+		return ""
+	}
+
+	return strings.TrimSpace(fmt.Sprint(value))
+}
+
+func getSubmissionLines(submission map[string]any, name string) []string {
+	value := getSubmissionString(submission, name)
+	if value == "" {
+		return nil
+	}
+
+	return strings.Split(value, "\n")
+}
+
+// parseTeamWelcomeSubmission builds a message from a dialog submission. Invalid fields are
+// reported with the name of the element.
+func parseTeamWelcomeSubmission(submission map[string]any) (*ConfigMessage, map[string]string) {
+	message := &ConfigMessage{
+		TeamName:          strings.ToLower(getSubmissionString(submission, "team_name")),
+		Message:           getSubmissionLines(submission, "message"),
+		AttachmentMessage: getSubmissionLines(submission, "attachment_message"),
+		RejoinPolicy:      getSubmissionString(submission, "rejoin_policy"),
+	}
+	fieldErrors := make(map[string]string)
+
+	if value := getSubmissionString(submission, "delay_in_seconds"); value != "" {
+		delay, err := strconv.ParseFloat(value, 64)
+		if err != nil || delay < 0 {
+			fieldErrors["delay_in_seconds"] = "Must be a positive number."
+		}
+		message.DelayInSeconds = int(delay)
+	}
+
+	if value := getSubmissionString(submission, "rejoin_after_days"); value != "" {
+		days, err := strconv.ParseFloat(value, 64)
+		if err != nil || days < 0 {
+			fieldErrors["rejoin_after_days"] = "Must be a positive number."
+		}
+		message.RejoinAfterDays = int(days)
+	}
+
+	message.IncludeGuests, _ = strconv.ParseBool(getSubmissionString(submission, "include_guests"))
+
+	if value := getSubmissionString(submission, "actions"); value != "" {
+		if err := json.Unmarshal([]byte(value), &message.Actions); err != nil {
+			fieldErrors["actions"] = fmt.Sprintf("Invalid JSON: %s", err)
+		}
+	}
+
+	if value := getSubmissionString(submission, "translations"); value != "" {
+		if err := json.Unmarshal([]byte(value), &message.Translations); err != nil {
+			fieldErrors["translations"] = fmt.Sprintf("Invalid JSON: %s", err)
+		}
+	}
+
+	if value := getSubmissionString(submission, "steps"); value != "" {
+		if err := json.Unmarshal([]byte(value), &message.Steps); err != nil {
+			fieldErrors["steps"] = fmt.Sprintf("Invalid JSON: %s", err)
+		}
+	}
+
+	if value := getSubmissionString(submission, "variants"); value != "" {
+		if err := json.Unmarshal([]byte(value), &message.Variants); err != nil {
+			fieldErrors["variants"] = fmt.Sprintf("Invalid JSON: %s", err)
+		}
+	}
+
+	if value := getSubmissionString(submission, "conditions"); value != "" {
+		if err := json.Unmarshal([]byte(value), &message.Conditions); err != nil {
+			fieldErrors["conditions"] = fmt.Sprintf("Invalid JSON: %s", err)
+		}
+	}
+
+	if message.isEmpty() {
+		fieldErrors["message"] = "Please provide a message, an attachment message, actions, steps or variants."
+	}
+
+	return message, fieldErrors
+}
+
+// handleTeamWelcomeDialog saves the team welcome message submitted through the dialog.
+func (p *Plugin) handleTeamWelcomeDialog(w http.ResponseWriter, r *http.Request) {
+	var request *model.SubmitDialogRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request == nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	mattermostUserID := r.Header.Get("Mattermost-User-Id")
+	if mattermostUserID == "" || mattermostUserID != request.UserId {
+		http.Error(w, "not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	if request.Cancelled {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	message, fieldErrors := parseTeamWelcomeSubmission(request.Submission)
+	if _, appErr := p.API.GetTeamByName(message.TeamName); appErr != nil {
+		fieldErrors["team_name"] = "The team has not been found."
+	} else if !p.canManageTeamWelcome(request.UserId, message.TeamName) {
+		fieldErrors["team_name"] = "Only system admins and admins of the team can manage its welcome messages."
+	}
+
+	message.ID = request.State
+	if message.ID != "" {
+		existing, err := p.getStoredWelcomeMessage(message.ID)
+		if err != nil {
+			p.API.LogError("failed to get stored welcome message", "id", message.ID, "error", err.Error())
+			p.encodeDialogResponse(w, &model.SubmitDialogResponse{Error: "Failed to get the welcome message."})
+			return
+		}
+		if existing == nil {
+			p.encodeDialogResponse(w, &model.SubmitDialogResponse{Error: "The welcome message has been deleted in the meantime."})
+			return
+		}
+		if !p.canManageTeamWelcome(request.UserId, existing.TeamName) {
+			p.encodeDialogResponse(w, &model.SubmitDialogResponse{Error: "Only system admins and admins of the team can manage its welcome messages."})
+			return
+		}
+	} else {
+		message.ID = model.NewId()
+	}
+
+	if len(fieldErrors) > 0 {
+		p.encodeDialogResponse(w, &model.SubmitDialogResponse{Errors: fieldErrors})
+		return
+	}
+
+	problems := p.checkWelcomeMessage(message)
+	problems = append(problems, checkActionNamesInTeam(message, p.getWelcomeMessages())...)
+	if len(problems) > 0 {
+		p.encodeDialogResponse(w, &model.SubmitDialogResponse{Error: "The welcome message is invalid: " + strings.Join(problems, "; ") + "."})
+		return
+	}
+
+	if err := p.saveStoredWelcomeMessage(message); err != nil {
+		p.API.LogError("failed to save stored welcome message", "id", message.ID, "error", err.Error())
+		p.encodeDialogResponse(w, &model.SubmitDialogResponse{Error: "Failed to save the welcome message."})
+		return
+	}
+
+	_ = p.API.SendEphemeralPost(request.UserId, &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: request.ChannelId,
+		Message:   fmt.Sprintf("Saved the welcome message `%s` for team `%s`.", message.ID, message.TeamName),
+	})
+	w.WriteHeader(http.StatusOK)
+}
+
+func (p *Plugin) encodeDialogResponse(w http.ResponseWriter, response *model.SubmitDialogResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		p.API.LogWarn("failed to write SubmitDialogResponse")
+	}
+}
+
+// formatTeamWelcomeList describes all the team welcome messages along with their source.
+func formatTeamWelcomeList(messages []*ConfigMessage) string {
+	if len(messages) == 0 {
+		return "There are no welcome messages you can manage"
+	}
+
+	var str strings.Builder
+	str.WriteString("| ID | Team | Source | Delay in seconds | Steps |\n|---|---|---|---|---|\n")
+	for _, message := range messages {
+		source := "config.json"
+		if message.Source == messageSourceStore {
+			source = "managed in Mattermost"
+		}
+
+		str.WriteString(fmt.Sprintf("| `%s` | %s | %s | %d | %d |\n",
+			message.ID, message.TeamName, source, message.DelayInSeconds, len(message.getSteps())))
+	}
+
+	return str.String()
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTeamWelcomeDialogRoundTrip(t *testing.T) {
+	message := &ConfigMessage{
+		ID:                "message-id",
+		TeamName:          "staff",
+		Message:           []string{"Welcome {{.UserDisplayName}}!", "Enjoy."},
+		AttachmentMessage: []string{"Pick a channel"},
+		DelayInSeconds:    5,
+		IncludeGuests:     true,
+		RejoinPolicy:      rejoinPolicyAfterDays,
+		RejoinAfterDays:   30,
+		Actions:           []*ConfigMessageAction{{ActionType: actionTypeButton, ActionName: "alerts", ActionDisplayName: "Alerts", ChannelsAddedTo: []string{"alerts"}}},
+		Translations:      map[string]*ConfigMessageTranslation{"fr": {Message: []string{"Bienvenue !"}}},
+		Steps:             []*ConfigMessageStep{{DelayInSeconds: 60, Message: []string{"Any question?"}}},
+		Variants:          []*ConfigMessageVariant{{Name: "short", Weight: 1, Message: []string{"Hi!"}}},
+	}
+
+	submission := make(map[string]any)
+	for _, element := range getTeamWelcomeDialog(message).Elements {
+		submission[element.Name] = element.Default
+	}
+
+	parsed, fieldErrors := parseTeamWelcomeSubmission(submission)
+	if len(fieldErrors) > 0 {
+		t.Fatalf("unexpected errors %v", fieldErrors)
+	}
+
+	parsed.ID = message.ID
+	if !reflect.DeepEqual(parsed, message) {
+		t.Errorf("expected %+v, got %+v", message, parsed)
+	}
+}
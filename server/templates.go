@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// getTemplateFuncs returns the functions available in all the message templates. They are
+// documented in the README. Functions producing links or mentions return markdown values, which
+// are not escaped when printed.
+func (p *Plugin) getTemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		escapeMarkdownFunc: escapeMarkdownValue,
+
+		"now":        time.Now,
+		"formatDate": formatDate,
+
+		"channelLink": channelLink,
+		"channelURL": func(teamName, channelName string) markdown {
+			return channelURL(p.getSiteURL(), teamName, channelName)
+		},
+		"mention": mention,
+
+		"pluralize": pluralize,
+		"join":      join,
+		"upper":     strings.ToUpper,
+		"lower":     strings.ToLower,
+		"title":     title,
+		"trim":      strings.TrimSpace,
+		"default":   defaultValue,
+
+		"isGuest":       isGuest,
+		"isSystemAdmin": isSystemAdmin,
+		"hasRole":       hasRole,
+	}
+}
+
+// parseTemplate parses the lines of a message template, with the template functions registered.
+// The values printed by the template are escaped for Markdown.
+func (p *Plugin) parseTemplate(name string, lines []string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(p.getTemplateFuncs()).Parse(strings.Join(lines, "\n"))
+	if err != nil {
+		return nil, err
+	}
+	escapeMarkdownTemplate(tmpl)
+
+	return tmpl, nil
+}
+
+// formatDate formats a time.Time, or a timestamp in milliseconds such as User.CreateAt, in the
+// timezone of the user. UTC is used when the user has no timezone.
+func formatDate(layout string, user *model.User, value interface{}) (string, error) {
+	var t time.Time
+	switch v := value.(type) {
+	case time.Time:
+		t = v
+	case int64:
+		t = time.UnixMilli(v)
+	case int:
+		t = time.UnixMilli(int64(v))
+	default:
+		return "", fmt.Errorf("formatDate: unsupported value of type %T", value)
+	}
+
+	location := time.UTC
+	if user != nil {
+		if name := user.GetPreferredTimezone(); name != "" {
+			if userLocation, err := time.LoadLocation(name); err == nil {
+				location = userLocation
+			}
+		}
+	}
+
+	return t.In(location).Format(layout), nil
+}
+
+// channelLink returns the ~name reference of a channel, rendered as a link by Mattermost. A name
+// which is not a valid channel name is escaped, so that it can't change the formatting.
+func channelLink(channel interface{}) markdown {
+	if c, ok := channel.(*model.Channel); ok {
+		if c == nil {
+			return ""
+		}
+		return markdown("~" + c.Name)
+	}
+
+	name := strings.TrimPrefix(fmt.Sprint(channel), "~")
+	if !model.IsValidChannelIdentifier(name) {
+		return markdown(escapeMarkdown("~" + name))
+	}
+	return markdown("~" + name)
+}
+
+// channelURL returns the URL of a channel. Names which are not valid team or channel names are
+// encoded, and the URL is then escaped, so that they can't change the formatting.
+func channelURL(siteURL, teamName, channelName string) markdown {
+	siteURL = strings.TrimSuffix(siteURL, "/")
+	if !model.IsValidTeamName(teamName) || !model.IsValidChannelIdentifier(channelName) {
+		return markdown(escapeMarkdown(fmt.Sprintf("%s/%s/channels/%s", siteURL, url.PathEscape(teamName), url.PathEscape(channelName))))
+	}
+
+	return markdown(fmt.Sprintf("%s/%s/channels/%s", siteURL, teamName, channelName))
+}
+
+// mention returns the @username mention of a user. A name which is not a valid username, or which
+// would notify the whole channel, is escaped.
+func mention(user interface{}) markdown {
+	if u, ok := user.(*model.User); ok {
+		if u == nil {
+			return ""
+		}
+		return markdown("@" + u.Username)
+	}
+
+	username := strings.TrimPrefix(fmt.Sprint(user), "@")
+	if !model.IsValidUsername(username) || channelMentionRegexp.MatchString("@"+username) {
+		return markdown(escapeMarkdown("@" + username))
+	}
+	return markdown("@" + username)
+}
+
+// pluralize returns the singular form when count is 1, and the plural form otherwise
+func pluralize(count interface{}, singular, plural string) (string, error) {
+	value := reflect.ValueOf(count)
+	var n float64
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = float64(value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = float64(value.Uint())
+	case reflect.Float32, reflect.Float64:
+		n = value.Float()
+	case reflect.Slice, reflect.Array, reflect.Map:
+		n = float64(value.Len())
+	default:
+		return "", fmt.Errorf("pluralize: unsupported count of type %T", count)
+	}
+
+	if n == 1 {
+		return singular, nil
+	}
+	return plural, nil
+}
+
+// join concatenates the items of a list, separated by sep. Users are printed by username and
+// channels by display name.
+func join(sep string, list interface{}) (string, error) {
+	value := reflect.ValueOf(list)
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		return "", fmt.Errorf("join: unsupported list of type %T", list)
+	}
+
+	items := make([]string, 0, value.Len())
+	for i := 0; i < value.Len(); i++ {
+		switch item := value.Index(i).Interface().(type) {
+		case *model.User:
+			items = append(items, item.Username)
+		case *model.Channel:
+			items = append(items, item.DisplayName)
+		default:
+			items = append(items, fmt.Sprint(item))
+		}
+	}
+
+	return strings.Join(items, sep), nil
+}
+
+// title upper cases the first letter of every word
+func title(s string) string {
+	runes := []rune(s)
+	for i, r := range runes {
+		if i == 0 || unicode.IsSpace(runes[i-1]) {
+			runes[i] = unicode.ToUpper(r)
+		}
+	}
+
+	return string(runes)
+}
+
+// defaultValue returns value, unless it is empty in which case fallback is returned
+func defaultValue(fallback, value interface{}) interface{} {
+	if value == nil {
+		return fallback
+	}
+	if v := reflect.ValueOf(value); v.IsZero() {
+		return fallback
+	}
+
+	return value
+}
+
+func isGuest(user *model.User) bool {
+	return user != nil && user.IsGuest()
+}
+
+func isSystemAdmin(user *model.User) bool {
+	return user != nil && user.IsSystemAdmin()
+}
+
+// hasRole tells whether the user has the given role, e.g. system_user
+func hasRole(user *model.User, role string) bool {
+	return user != nil && user.IsInRole(role)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestFormatDate(t *testing.T) {
+	date := time.Date(2024, time.March, 1, 23, 30, 0, 0, time.UTC)
+
+	for name, tc := range map[string]struct {
+		user     *model.User
+		value    interface{}
+		expected string
+	}{
+		"time without user": {
+			value:    date,
+			expected: "2024-03-01 23:30",
+		},
+		"timestamp in milliseconds": {
+			value:    date.UnixMilli(),
+			expected: "2024-03-01 23:30",
+		},
+		"user with manual timezone": {
+			user:     &model.User{Timezone: map[string]string{"useAutomaticTimezone": "false", "manualTimezone": "Europe/Paris"}},
+			value:    date,
+			expected: "2024-03-02 00:30",
+		},
+		"user with unknown timezone": {
+			user:     &model.User{Timezone: map[string]string{"useAutomaticTimezone": "false", "manualTimezone": "Nowhere/Unknown"}},
+			value:    date,
+			expected: "2024-03-01 23:30",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			formatted, err := formatDate("2006-01-02 15:04", tc.user, tc.value)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if formatted != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, formatted)
+			}
+		})
+	}
+
+	if _, err := formatDate("2006", nil, "yesterday"); err == nil {
+		t.Error("expected an error for an unsupported value")
+	}
+}
+
+func TestPluralize(t *testing.T) {
+	for name, tc := range map[string]struct {
+		count    interface{}
+		expected string
+	}{
+		"zero":          {count: 0, expected: "channels"},
+		"one":           {count: 1, expected: "channel"},
+		"many":          {count: int64(3), expected: "channels"},
+		"list of one":   {count: []string{"town-square"}, expected: "channel"},
+		"list of many":  {count: []string{"town-square", "off-topic"}, expected: "channels"},
+		"unsigned one":  {count: uint(1), expected: "channel"},
+		"floating many": {count: 2.5, expected: "channels"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			word, err := pluralize(tc.count, "channel", "channels")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if word != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, word)
+			}
+		})
+	}
+}
+
+func TestJoin(t *testing.T) {
+	joined, err := join(", ", []string{"town-square", "off-topic"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if joined != "town-square, off-topic" {
+		t.Errorf("unexpected result %q", joined)
+	}
+
+	joined, err = join(" and ", []*model.User{{Username: "jane"}, {Username: "john"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if joined != "jane and john" {
+		t.Errorf("unexpected result %q", joined)
+	}
+
+	if _, err := join(", ", "town-square"); err == nil {
+		t.Error("expected an error for a value which is not a list")
+	}
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	user := &model.User{Username: "jane", Roles: "system_user system_guest"}
+	p := &Plugin{}
+
+	for name, tc := range map[string]struct {
+		template string
+		expected string
+	}{
+		"mention":              {template: `{{mention .}}`, expected: "@jane"},
+		"mention username":     {template: `{{mention "@john"}}`, expected: "@john"},
+		"channel link":         {template: `{{channelLink "off-topic"}}`, expected: "~off-topic"},
+		"invalid channel link": {template: `{{channelLink "x](http://example.com)"}}`, expected: `\~x\](http://example.com)`},
+		"invalid mention":      {template: `{{mention "*bold*"}}`, expected: `@\*bold\*`},
+		"mention of channel":   {template: `{{mention "here"}}`, expected: "@\u200bhere"},
+		"title":                {template: `{{title "welcome to the team"}}`, expected: "Welcome To The Team"},
+		"default when empty":   {template: `{{default "there" .FirstName}}`, expected: "there"},
+		"default when set":     {template: `{{default "there" .Username}}`, expected: "jane"},
+		"is guest":             {template: `{{if isGuest .}}guest{{else}}member{{end}}`, expected: "guest"},
+		"has role":             {template: `{{if hasRole . "system_admin"}}admin{{else}}user{{end}}`, expected: "user"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tmpl, err := p.parseTemplate("Test", []string{tc.template})
+			if err != nil {
+				t.Fatalf("failed to parse the template: %v", err)
+			}
+
+			var rendered strings.Builder
+			if err := tmpl.Execute(&rendered, user); err != nil {
+				t.Fatalf("failed to execute the template: %v", err)
+			}
+			if rendered.String() != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, rendered.String())
+			}
+		})
+	}
+}
+
+func TestChannelURL(t *testing.T) {
+	for name, tc := range map[string]struct {
+		teamName    string
+		channelName string
+		expected    markdown
+	}{
+		"valid":           {teamName: "staff", channelName: "off-topic", expected: "https://chat.example.com/staff/channels/off-topic"},
+		"invalid channel": {teamName: "staff", channelName: "@channel **pwn**", expected: "https://chat.example.com/staff/channels/@\u200bchannel%20%2A%2Apwn%2A%2A"},
+		"invalid team":    {teamName: "[x](y)", channelName: "off-topic", expected: `https://chat.example.com/%5Bx%5D%28y%29/channels/off-topic`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if url := channelURL("https://chat.example.com/", tc.teamName, tc.channelName); url != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, url)
+			}
+		})
+	}
+}
+
+func TestChannelMessageTemplate(t *testing.T) {
+	p := &Plugin{}
+	data := &ChannelMessageTemplate{
+		MessageTemplate: MessageTemplate{
+			User:            &model.User{Username: "alice"},
+			Team:            &model.Team{Name: "staff"},
+			UserDisplayName: "Alice",
+		},
+		Channel: &model.Channel{Name: "project-x", DisplayName: "Project X"},
+	}
+
+	tmpl, err := p.parseTemplate("ChannelResponse", []string{"Welcome to {{channelLink .Channel}} of {{.Team.Name}}, {{.UserDisplayName}}!{{with .Actor}} Added by {{mention .}}.{{end}}{{range .ChannelAdmins}} {{mention .}}{{end}}"})
+	if err != nil {
+		t.Fatalf("failed to parse the template: %v", err)
+	}
+
+	var rendered strings.Builder
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		t.Fatalf("failed to execute the template: %v", err)
+	}
+	if expected := "Welcome to ~project-x of staff, Alice!"; rendered.String() != expected {
+		t.Errorf("expected %q, got %q", expected, rendered.String())
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// validateWelcomeMessages checks all the welcome messages of the configuration, and returns an
+// error listing every problem found. Teams and channels which can't be found are returned as
+// warnings instead: they may only be missing for a while, and the other messages must keep working.
+func (p *Plugin) validateWelcomeMessages(messages []*ConfigMessage) ([]string, error) {
+	var problems, warnings []string
+
+	ids := make(map[string]bool)
+	actionNames := make(map[string]string)
+	for i, message := range messages {
+		if message == nil {
+			problems = append(problems, fmt.Sprintf("welcome message #%d is empty", i+1))
+			continue
+		}
+
+		if ids[message.ID] {
+			problems = append(problems, fmt.Sprintf("welcome message `%s`: the ID is used by another welcome message", message.ID))
+		}
+		ids[message.ID] = true
+
+		for _, problem := range p.checkWelcomeMessageContent(message) {
+			problems = append(problems, fmt.Sprintf("welcome message `%s`: %s", message.ID, problem))
+		}
+		for _, problem := range p.checkWelcomeMessageEnvironment(message) {
+			warnings = append(warnings, fmt.Sprintf("welcome message `%s`: %s", message.ID, problem))
+		}
+		for _, problem := range checkActionNames(message, actionNames) {
+			problems = append(problems, fmt.Sprintf("welcome message `%s`: %s", message.ID, problem))
+		}
+	}
+
+	if len(problems) > 0 {
+		return warnings, errors.Errorf("invalid welcome messages:\n * %s", strings.Join(problems, "\n * "))
+	}
+
+	return warnings, nil
+}
+
+// checkActionNames lists the action names of a welcome message which are already used by another
+// message of its team, and records them in actionNames, which maps a team and action name to the ID
+// of the message defining it. Button clicks are matched with their action by team and action name,
+// which must then be unique within a team.
+func checkActionNames(message *ConfigMessage, actionNames map[string]string) []string {
+	var problems []string
+	for _, action := range message.getActions() {
+		if action == nil || action.ActionName == "" {
+			continue
+		}
+
+		key := message.TeamName + "/" + action.ActionName
+		if otherID, ok := actionNames[key]; ok && otherID != message.ID {
+			problems = append(problems, fmt.Sprintf("action `%s` is also defined by welcome message `%s` of the same team", action.ActionName, otherID))
+		}
+		actionNames[key] = message.ID
+	}
+
+	return problems
+}
+
+// checkActionNamesInTeam lists the action names of a welcome message which are already used by the
+// other given messages of its team.
+func checkActionNamesInTeam(message *ConfigMessage, others []*ConfigMessage) []string {
+	actionNames := make(map[string]string)
+	for _, other := range others {
+		if other != nil && other.TeamName == message.TeamName && other.ID != message.ID {
+			checkActionNames(other, actionNames)
+		}
+	}
+
+	return checkActionNames(message, actionNames)
+}
+
+// checkWelcomeMessage lists the problems of a single welcome message, including the teams and
+// channels that don't exist.
+func (p *Plugin) checkWelcomeMessage(message *ConfigMessage) []string {
+	return append(p.checkWelcomeMessageContent(message), p.checkWelcomeMessageEnvironment(message)...)
+}
+
+// checkWelcomeMessageEnvironment lists the team and the channels of the actions of a welcome
+// message that don't exist.
+func (p *Plugin) checkWelcomeMessageEnvironment(message *ConfigMessage) []string {
+	if message.TeamName == "" {
+		return nil
+	}
+
+	team, appErr := p.API.GetTeamByName(message.TeamName)
+	if appErr != nil {
+		return []string{fmt.Sprintf("team `%s` has not been found", message.TeamName)}
+	}
+
+	var problems []string
+	checked := make(map[string]bool)
+	for _, action := range message.getActions() {
+		if action == nil {
+			continue
+		}
+		for _, channelName := range action.ChannelsAddedTo {
+			if checked[channelName] {
+				continue
+			}
+			checked[channelName] = true
+			if _, appErr := p.API.GetChannelByName(team.Id, channelName, false); appErr != nil {
+				problems = append(problems, fmt.Sprintf("channel `%s` of action `%s` has not been found in team `%s`", channelName, action.ActionName, team.Name))
+			}
+		}
+	}
+
+	return problems
+}
+
+// checkWelcomeMessageContent lists the problems of a welcome message which don't depend on the
+// environment: templates that don't parse and malformed actions, steps and variants.
+func (p *Plugin) checkWelcomeMessageContent(message *ConfigMessage) []string {
+	var problems []string
+	addProblem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if message.TeamName == "" {
+		addProblem("the team name is required")
+	}
+
+	if err := checkRejoinPolicy(message.RejoinPolicy, message.RejoinAfterDays); err != nil {
+		addProblem("%s", err.Error())
+	}
+
+	if message.Conditions != nil {
+		problems = append(problems, message.Conditions.check()...)
+	}
+
+	for i, step := range message.Steps {
+		if step == nil {
+			addProblem("step #%d is empty", i+1)
+		}
+	}
+
+	checkStep := func(where string, step *ConfigMessageStep, actionNames map[string]bool) {
+		if step.DelayInSeconds < 0 {
+			addProblem("the delay of %s must not be negative", where)
+		}
+		if _, err := p.parseTemplate("Response", step.Message); err != nil {
+			addProblem("the message template of %s is invalid: %s", where, err.Error())
+		}
+		if _, err := p.parseTemplate("AttachmentResponse", step.AttachmentMessage); err != nil {
+			addProblem("the attachment message template of %s is invalid: %s", where, err.Error())
+		}
+		for locale, translation := range step.Translations {
+			if translation == nil {
+				addProblem("the %s translation of %s is empty", locale, where)
+				continue
+			}
+			if _, err := p.parseTemplate("Response", translation.Message); err != nil {
+				addProblem("the %s message template of %s is invalid: %s", locale, where, err.Error())
+			}
+			if _, err := p.parseTemplate("AttachmentResponse", translation.AttachmentMessage); err != nil {
+				addProblem("the %s attachment message template of %s is invalid: %s", locale, where, err.Error())
+			}
+		}
+
+		problems = append(problems, p.checkActions(where, step.Actions, nil, actionNames)...)
+	}
+
+	steps := message.getSteps()
+	// The first step is the top level message, unless only the follow-up steps have content
+	firstStep := len(steps) - len(message.Steps)
+
+	actionNames := make(map[string]bool)
+	for i, step := range steps {
+		if step == nil {
+			continue
+		}
+
+		where := "the message"
+		if i >= firstStep {
+			where = fmt.Sprintf("step #%d", i-firstStep+1)
+		}
+		checkStep(where, step, actionNames)
+	}
+
+	problems = append(problems, p.checkVariants(message)...)
+	// Variants replace the top level message, so their actions may have the same names as the ones
+	// of the other variants
+	for _, variant := range message.Variants {
+		if variant == nil {
+			continue
+		}
+		step := &ConfigMessageStep{
+			Message:           variant.Message,
+			AttachmentMessage: variant.AttachmentMessage,
+			Actions:           variant.Actions,
+			Translations:      variant.Translations,
+		}
+		checkStep(fmt.Sprintf("variant `%s`", variant.Name), step, make(map[string]bool))
+	}
+
+	return problems
+}
+
+// checkRejoinPolicy makes sure a rejoin policy is known, and has its number of days when needed
+func checkRejoinPolicy(policy string, afterDays int) error {
+	switch policy {
+	case "", rejoinPolicyAlways, rejoinPolicyNever:
+	case rejoinPolicyAfterDays:
+		if afterDays <= 0 {
+			return errors.Errorf("RejoinAfterDays must be positive with the `%s` rejoin policy", rejoinPolicyAfterDays)
+		}
+	default:
+		return errors.Errorf("unknown rejoin policy `%s`, expected one of `%s`, `%s` or `%s`", policy, rejoinPolicyAlways, rejoinPolicyNever, rejoinPolicyAfterDays)
+	}
+
+	return nil
+}
+
+// checkActions lists the problems of the actions of a message. The channels the actions add users to
+// are looked up in the team, if known. Action names are collected in actionNames, to detect
+// duplicates across the steps of a message.
+func (p *Plugin) checkActions(where string, actions []*ConfigMessageAction, team *model.Team, actionNames map[string]bool) []string {
+	var problems []string
+	addProblem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	for j, action := range actions {
+		if action == nil {
+			addProblem("action #%d of %s is empty", j+1, where)
+			continue
+		}
+
+		name := action.ActionName
+		if name == "" {
+			name = fmt.Sprintf("#%d", j+1)
+		}
+
+		switch action.ActionType {
+		case actionTypeAutomatic:
+		case actionTypeButton:
+			if action.ActionName == "" {
+				addProblem("button action %s of %s has no ActionName", name, where)
+			}
+			if action.ActionDisplayName == "" {
+				addProblem("button action %s of %s has no ActionDisplayName", name, where)
+			}
+		default:
+			addProblem("action %s of %s has the unknown type `%s`, expected `%s` or `%s`", name, where, action.ActionType, actionTypeButton, actionTypeAutomatic)
+		}
+
+		if action.ActionName != "" {
+			if actionNames[action.ActionName] {
+				addProblem("action `%s` is defined more than once", action.ActionName)
+			}
+			actionNames[action.ActionName] = true
+		}
+
+		if _, err := p.parseTemplate("Response", action.ActionSuccessfulMessage); err != nil {
+			addProblem("the successful message template of action %s is invalid: %s", name, err.Error())
+		}
+		for locale, translation := range action.Translations {
+			if translation == nil {
+				addProblem("the %s translation of action %s is empty", locale, name)
+				continue
+			}
+			if _, err := p.parseTemplate("Response", translation.ActionSuccessfulMessage); err != nil {
+				addProblem("the %s successful message template of action %s is invalid: %s", locale, name, err.Error())
+			}
+		}
+
+		if team == nil {
+			continue
+		}
+		for _, channelName := range action.ChannelsAddedTo {
+			if _, appErr := p.API.GetChannelByName(team.Id, channelName, false); appErr != nil {
+				addProblem("channel `%s` of action %s has not been found in team `%s`", channelName, name, team.Name)
+			}
+		}
+	}
+
+	return problems
+}
+
+// checkVariants lists the problems of the variants of a message, other than the ones of their
+// content
+func (p *Plugin) checkVariants(message *ConfigMessage) []string {
+	var problems []string
+	names := make(map[string]bool)
+	totalWeight := 0
+	for i, variant := range message.Variants {
+		if variant == nil {
+			problems = append(problems, fmt.Sprintf("variant #%d is empty", i+1))
+			continue
+		}
+
+		if variant.Name == "" {
+			problems = append(problems, fmt.Sprintf("variant #%d has no name", i+1))
+		} else if names[variant.Name] {
+			problems = append(problems, fmt.Sprintf("variant `%s` is defined more than once", variant.Name))
+		}
+		names[variant.Name] = true
+
+		if variant.Weight < 0 {
+			problems = append(problems, fmt.Sprintf("the weight of variant `%s` must not be negative", variant.Name))
+		}
+		totalWeight += variant.Weight
+	}
+
+	if len(message.Variants) > 0 && totalWeight <= 0 {
+		problems = append(problems, "at least one variant must have a positive weight")
+	}
+
+	return problems
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestValidateWelcomeMessages(t *testing.T) {
+	p := newTestPlugin(&fakeAPI{
+		teams:    map[string]*model.Team{"team": {Id: "team-id", Name: "team"}},
+		channels: map[string]*model.Channel{"town-square": {Id: "channel-id", TeamId: "team-id", Name: "town-square"}},
+	})
+
+	action := func(name string, channels ...string) *ConfigMessageAction {
+		return &ConfigMessageAction{ActionType: actionTypeAutomatic, ActionName: name, ChannelsAddedTo: channels}
+	}
+
+	for name, tc := range map[string]struct {
+		messages []*ConfigMessage
+		warnings int
+		valid    bool
+	}{
+		"valid": {
+			messages: []*ConfigMessage{{TeamName: "team", Message: []string{"Hi"}, Actions: []*ConfigMessageAction{action("join", "town-square")}}},
+			valid:    true,
+		},
+		"unknown team": {
+			messages: []*ConfigMessage{{TeamName: "other", Message: []string{"Hi"}}},
+			warnings: 1,
+			valid:    true,
+		},
+		"unknown channel": {
+			messages: []*ConfigMessage{{TeamName: "team", Message: []string{"Hi"}, Actions: []*ConfigMessageAction{action("join", "town-square", "off-topic")}}},
+			warnings: 1,
+			valid:    true,
+		},
+		"empty step": {
+			messages: []*ConfigMessage{{TeamName: "team", Message: []string{"Hi"}, Steps: []*ConfigMessageStep{nil}}},
+		},
+		"invalid template": {
+			messages: []*ConfigMessage{{TeamName: "team", Message: []string{"{{.Unclosed"}}},
+		},
+		"unknown action type": {
+			messages: []*ConfigMessage{{TeamName: "team", Message: []string{"Hi"}, Actions: []*ConfigMessageAction{{ActionType: "other", ActionName: "join"}}}},
+		},
+		"invalid rejoin policy": {
+			messages: []*ConfigMessage{{TeamName: "team", Message: []string{"Hi"}, RejoinPolicy: "sometimes"}},
+		},
+		"duplicate IDs": {
+			messages: []*ConfigMessage{{ID: "a", TeamName: "team", Message: []string{"Hi"}}, {ID: "a", TeamName: "team", Message: []string{"Hello"}}},
+		},
+		"action name used twice in a team": {
+			messages: []*ConfigMessage{
+				{TeamName: "team", Message: []string{"Hi"}, Actions: []*ConfigMessageAction{action("join")}},
+				{TeamName: "team", Message: []string{"Hello"}, Actions: []*ConfigMessageAction{action("join")}},
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			warnings, err := p.validateWelcomeMessages(tc.messages)
+			if (err == nil) != tc.valid {
+				t.Errorf("expected valid %v, got error %v", tc.valid, err)
+			}
+			if len(warnings) != tc.warnings {
+				t.Errorf("expected %d warnings, got %q", tc.warnings, strings.Join(warnings, "; "))
+			}
+		})
+	}
+}
+
+func TestCheckActionNamesInTeam(t *testing.T) {
+	message := func(id, teamName string, actionNames ...string) *ConfigMessage {
+		message := &ConfigMessage{ID: id, TeamName: teamName, Message: []string{"Hi"}}
+		for _, name := range actionNames {
+			message.Steps = append(message.Steps, &ConfigMessageStep{Actions: []*ConfigMessageAction{{ActionType: actionTypeButton, ActionName: name}}})
+		}
+		return message
+	}
+
+	for name, tc := range map[string]struct {
+		message  *ConfigMessage
+		others   []*ConfigMessage
+		problems int
+	}{
+		"no other messages": {message: message("a", "team", "join", "leave")},
+		"distinct names":    {message: message("a", "team", "join"), others: []*ConfigMessage{message("b", "team", "leave")}},
+		"other team":        {message: message("a", "team", "join"), others: []*ConfigMessage{message("b", "other", "join")}},
+		"same message":      {message: message("a", "team", "join"), others: []*ConfigMessage{message("a", "team", "join")}},
+		"same name":         {message: message("a", "team", "join"), others: []*ConfigMessage{message("b", "team", "join")}, problems: 1},
+		"same name in a variant": {
+			message:  &ConfigMessage{ID: "a", TeamName: "team", Variants: []*ConfigMessageVariant{{Name: "v", Actions: []*ConfigMessageAction{{ActionType: actionTypeButton, ActionName: "join"}}}}},
+			others:   []*ConfigMessage{message("b", "team", "join")},
+			problems: 1,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if problems := checkActionNamesInTeam(tc.message, tc.others); len(problems) != tc.problems {
+				t.Errorf("expected %d problems, got %q", tc.problems, problems)
+			}
+		})
+	}
+}
+
+func TestConfigMessageIsEmpty(t *testing.T) {
+	for name, tc := range map[string]struct {
+		message  *ConfigMessage
+		expected bool
+	}{
+		"empty":              {message: &ConfigMessage{TeamName: "team"}, expected: true},
+		"message":            {message: &ConfigMessage{Message: []string{"Hi"}}},
+		"attachment message": {message: &ConfigMessage{AttachmentMessage: []string{"Hi"}}},
+		"actions":            {message: &ConfigMessage{Actions: []*ConfigMessageAction{{ActionType: actionTypeAutomatic}}}},
+		"steps":              {message: &ConfigMessage{Steps: []*ConfigMessageStep{{Message: []string{"Hi"}}}}},
+		"variants only":      {message: &ConfigMessage{Variants: []*ConfigMessageVariant{{Name: "a", Message: []string{"Hi"}}}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if tc.message.isEmpty() != tc.expected {
+				t.Errorf("expected %v", tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+const welcomebotVariantStatsKey = "variants_"
+
+// VariantStats are the counters of a variant of a welcome message
+type VariantStats struct {
+	// Number of users the variant has been assigned to
+	Assigned int64
+
+	// Number of users the variant has been posted to
+	Sent int64
+
+	// Number of users who clicked at least one button of the variant
+	Converted int64
+
+	// Number of clicks by action name
+	Clicks map[string]int64
+}
+
+func getVariantStatsKey(messageID string) string {
+	hash := sha256.Sum256([]byte(messageID))
+	return welcomebotVariantStatsKey + hex.EncodeToString(hash[:16])
+}
+
+// pickVariant chooses the variant of a message for a user. The choice only depends on the user,
+// the message and the weights of the variants, so it is the same on every node of the cluster.
+func pickVariant(message *ConfigMessage, userID string) *ConfigMessageVariant {
+	totalWeight := 0
+	for _, variant := range message.Variants {
+		if variant != nil && variant.Weight > 0 {
+			totalWeight += variant.Weight
+		}
+	}
+	if totalWeight == 0 {
+		return nil
+	}
+
+	hash := sha256.Sum256([]byte(userID + "/" + message.ID))
+	bucket := int(binary.BigEndian.Uint64(hash[:8]) % uint64(totalWeight))
+	for _, variant := range message.Variants {
+		if variant == nil || variant.Weight <= 0 {
+			continue
+		}
+		if bucket < variant.Weight {
+			return variant
+		}
+		bucket -= variant.Weight
+	}
+
+	return nil
+}
+
+// assignVariant returns the variant of the message for the user, and records it in the ledger
+// of the message. Users keep their variant as long as it is configured, even if the weights
+// change. An empty name is returned for messages without variants.
+func (p *Plugin) assignVariant(userID, teamID string, message *ConfigMessage) (string, error) {
+	if len(message.Variants) == 0 {
+		return "", nil
+	}
+
+	var name string
+	var assigned bool
+	err := p.client.KV.SetAtomicWithRetries(getLedgerKey(userID, teamID, message.ID), func(oldValue []byte) (interface{}, error) {
+		record, err := decodeDeliveryRecord(oldValue)
+		if err != nil {
+			return nil, err
+		}
+
+		name, assigned = record.Variant, false
+		if message.getVariant(name) != nil {
+			return record, nil
+		}
+
+		variant := pickVariant(message, userID)
+		if variant == nil {
+			return nil, errors.New("no variant has a positive weight")
+		}
+		name, assigned = variant.Name, true
+		record.Variant = name
+		record.VariantClickedAt = 0
+		record.VariantSentAt = 0
+		return record, nil
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to assign a variant")
+	}
+
+	if assigned {
+		p.updateVariantStats(message.ID, name, func(stats *VariantStats) {
+			stats.Assigned++
+		})
+	}
+
+	return name, nil
+}
+
+// recordVariantClick attributes a button click to the variant of the message it was posted with
+func (p *Plugin) recordVariantClick(userID, teamID, messageID, variant, actionName string) {
+	var converted bool
+	err := p.client.KV.SetAtomicWithRetries(getLedgerKey(userID, teamID, messageID), func(oldValue []byte) (interface{}, error) {
+		record, err := decodeDeliveryRecord(oldValue)
+		if err != nil {
+			return nil, err
+		}
+
+		converted = false
+		if record.Variant != variant || record.VariantClickedAt != 0 {
+			return record, nil
+		}
+		converted = true
+		record.VariantClickedAt = model.GetMillis()
+		return record, nil
+	})
+	if err != nil {
+		p.API.LogError("failed to record the click on the variant", "user_id", userID, "message_id", messageID, "variant", variant, "err", err.Error())
+	}
+
+	p.updateVariantStats(messageID, variant, func(stats *VariantStats) {
+		stats.Clicks[actionName]++
+		if converted {
+			stats.Converted++
+		}
+	})
+}
+
+// updateVariantStats atomically updates the counters of a variant, logging errors since it runs
+// while variants are assigned, sent and clicked.
+func (p *Plugin) updateVariantStats(messageID, variant string, update func(stats *VariantStats)) {
+	err := p.client.KV.SetAtomicWithRetries(getVariantStatsKey(messageID), func(oldValue []byte) (interface{}, error) {
+		stats, err := decodeVariantStats(oldValue)
+		if err != nil {
+			return nil, err
+		}
+
+		if stats[variant] == nil {
+			stats[variant] = &VariantStats{}
+		}
+		if stats[variant].Clicks == nil {
+			stats[variant].Clicks = make(map[string]int64)
+		}
+		update(stats[variant])
+		return stats, nil
+	})
+	if err != nil {
+		p.API.LogError("failed to update the statistics of the variant", "message_id", messageID, "variant", variant, "err", err.Error())
+	}
+}
+
+func decodeVariantStats(data []byte) (map[string]*VariantStats, error) {
+	stats := make(map[string]*VariantStats)
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &stats); err != nil {
+			return nil, err
+		}
+	}
+
+	return stats, nil
+}
+
+// getVariantStats returns the counters of the variants of a message, by variant name
+func (p *Plugin) getVariantStats(messageID string) (map[string]*VariantStats, error) {
+	var data []byte
+	if err := p.client.KV.Get(getVariantStatsKey(messageID), &data); err != nil {
+		return nil, errors.Wrap(err, "failed to get the statistics of the variants")
+	}
+
+	return decodeVariantStats(data)
+}
+
+// formatVariantStats renders the results of the variants of a message as a Markdown table. The
+// click-through rate is the share of the users who received the variant and clicked a button.
+func formatVariantStats(message *ConfigMessage, stats map[string]*VariantStats) string {
+	names := make([]string, 0, len(message.Variants))
+	weights := make(map[string]string)
+	for _, variant := range message.Variants {
+		if variant != nil {
+			names = append(names, variant.Name)
+			weights[variant.Name] = fmt.Sprint(variant.Weight)
+		}
+	}
+	// Variants removed from the configuration are still reported
+	var removed []string
+	for name := range stats {
+		if _, ok := weights[name]; !ok {
+			removed = append(removed, name)
+			weights[name] = "removed"
+		}
+	}
+	sort.Strings(removed)
+	names = append(names, removed...)
+
+	var str strings.Builder
+	str.WriteString(fmt.Sprintf("Variants of welcome message `%s`:\n\n", message.ID))
+	str.WriteString("| Variant | Weight | Assigned | Sent | Converted | Click-through | Clicks |\n|---|---|---|---|---|---|---|\n")
+	for _, name := range names {
+		variantStats := stats[name]
+		if variantStats == nil {
+			variantStats = &VariantStats{}
+		}
+
+		clickThrough := "-"
+		if variantStats.Sent > 0 {
+			clickThrough = fmt.Sprintf("%.1f%%", float64(variantStats.Converted)*100/float64(variantStats.Sent))
+		}
+
+		actions := make([]string, 0, len(variantStats.Clicks))
+		for action := range variantStats.Clicks {
+			actions = append(actions, action)
+		}
+		sort.Strings(actions)
+		clicks := make([]string, 0, len(actions))
+		for _, action := range actions {
+			clicks = append(clicks, fmt.Sprintf("`%s`: %d", action, variantStats.Clicks[action]))
+		}
+
+		str.WriteString(fmt.Sprintf("| %s | %s | %d | %d | %d | %s | %s |\n",
+			name, weights[name], variantStats.Assigned, variantStats.Sent, variantStats.Converted, clickThrough, strings.Join(clicks, ", ")))
+	}
+
+	return str.String()
+}
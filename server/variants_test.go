@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPickVariant(t *testing.T) {
+	message := &ConfigMessage{
+		ID: "staff-0",
+		Variants: []*ConfigMessageVariant{
+			{Name: "short", Weight: 1},
+			{Name: "long", Weight: 3},
+			{Name: "disabled", Weight: 0},
+		},
+	}
+
+	t.Run("stable", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			userID := fmt.Sprintf("user%d", i)
+			if first, second := pickVariant(message, userID), pickVariant(message, userID); first != second {
+				t.Errorf("expected the same variant for %s, got %s and %s", userID, first.Name, second.Name)
+			}
+		}
+	})
+
+	t.Run("weighted", func(t *testing.T) {
+		counts := make(map[string]int)
+		for i := 0; i < 4000; i++ {
+			counts[pickVariant(message, fmt.Sprintf("user%d", i)).Name]++
+		}
+
+		if counts["disabled"] != 0 {
+			t.Errorf("expected no user for a variant without weight, got %d", counts["disabled"])
+		}
+		if counts["short"] < 800 || counts["short"] > 1200 {
+			t.Errorf("expected about 1000 users for the short variant, got %d", counts["short"])
+		}
+		if counts["long"] < 2800 || counts["long"] > 3200 {
+			t.Errorf("expected about 3000 users for the long variant, got %d", counts["long"])
+		}
+	})
+
+	t.Run("no weight", func(t *testing.T) {
+		if variant := pickVariant(&ConfigMessage{Variants: []*ConfigMessageVariant{{Name: "a"}}}, "user"); variant != nil {
+			t.Errorf("expected no variant, got %s", variant.Name)
+		}
+	})
+}
+
+func TestWithVariant(t *testing.T) {
+	message := ConfigMessage{
+		ID:      "staff-0",
+		Message: []string{"Welcome"},
+		Steps:   []*ConfigMessageStep{{Message: []string{"Day two"}}},
+		Variants: []*ConfigMessageVariant{
+			{Name: "long", Weight: 1, Message: []string{"Welcome, here is everything you need to know"}},
+		},
+	}
+
+	variantMessage := message.withVariant("long")
+	if variantMessage.Variant != "long" || variantMessage.Message[0] != "Welcome, here is everything you need to know" {
+		t.Errorf("expected the content of the variant, got %q from %q", variantMessage.Message, variantMessage.Variant)
+	}
+	if steps := variantMessage.getSteps(); len(steps) != 2 || steps[1].Message[0] != "Day two" {
+		t.Errorf("expected the follow-up steps to be kept, got %d steps", len(steps))
+	}
+
+	if unknown := message.withVariant("unknown"); unknown.Variant != "" || unknown.Message[0] != "Welcome" {
+		t.Errorf("expected the message to be unchanged for an unknown variant, got %q", unknown.Message)
+	}
+}
@@ -3,15 +3,99 @@ package main
 import (
 	"bytes"
 	"fmt"
-	"html/template"
 	"strings"
-	"time"
 
 	"github.com/mattermost/mattermost/server/public/model"
 )
 
+// welcomeOptions change how the welcome flow of a team is run
+type welcomeOptions struct {
+	// Send the messages regardless of their rejoin policy
+	Force bool
+
+	// Don't run the automatic actions of the messages
+	SkipAutomaticActions bool
+
+	// Skip the messages which have already been sent to the user, or are being sent
+	SkipDelivered bool
+}
+
+// getUserWelcomeMessages lists the welcome messages of the team that apply to the user.
+func (p *Plugin) getUserWelcomeMessages(user *model.User, team *model.Team, options welcomeOptions) []*ConfigMessage {
+	var messages []*ConfigMessage
+	for _, message := range p.getTeamWelcomeMessages(team.Name) {
+		if user.IsGuest() && !message.IncludeGuests {
+			continue
+		}
+
+		matched, err := p.matchesConditions(message, user, team)
+		if err != nil {
+			p.API.LogError("failed to check the conditions of the welcome message", "user_id", user.Id, "message_id", message.ID, "err", err.Error())
+			continue
+		}
+		if !matched {
+			continue
+		}
+
+		if options.SkipDelivered {
+			record, err := p.getDeliveryRecord(user.Id, team.Id, message.ID)
+			if err != nil {
+				p.API.LogError("failed to check the delivery of the welcome message", "user_id", user.Id, "message_id", message.ID, "err", err.Error())
+				continue
+			}
+			if record.JoinedAt != 0 {
+				continue
+			}
+		}
+
+		messages = append(messages, message)
+	}
+
+	return messages
+}
+
+// startWelcomeFlow schedules the welcome messages of the team for the user and returns the number
+// of messages scheduled.
+func (p *Plugin) startWelcomeFlow(data *MessageTemplate, options welcomeOptions) int {
+	scheduled := 0
+	for _, message := range p.getUserWelcomeMessages(data.User, data.Team, options) {
+		joinedAt, err := p.startDeliveryRound(data.User.Id, data.Team.Id, message, options.Force)
+		if err == errDuplicateJoin || err == errRejoinNotAllowed {
+			p.API.LogDebug("not sending welcome message", "user_id", data.User.Id, "message_id", message.ID, "reason", err.Error())
+			continue
+		} else if err != nil {
+			p.API.LogError("failed to record team join", "user_id", data.User.Id, "team_id", data.Team.Id, "err", err.Error())
+			continue
+		}
+
+		variant, err := p.assignVariant(data.User.Id, data.Team.Id, message)
+		if err != nil {
+			p.API.LogError("failed to assign the variant of the welcome message", "user_id", data.User.Id, "message_id", message.ID, "err", err.Error())
+			continue
+		}
+		variantMessage := message.withVariant(variant)
+
+		job := WelcomeJob{
+			UserID:               data.User.Id,
+			TeamID:               data.Team.Id,
+			ActorID:              data.actorID(),
+			Variant:              variant,
+			JoinedAt:             joinedAt,
+			SkipAutomaticActions: options.SkipAutomaticActions,
+		}
+		if err := p.scheduleWelcomeMessage(job, &variantMessage); err != nil {
+			p.API.LogError("failed to schedule welcome message", "user_id", data.User.Id, "team_id", data.Team.Id, "err", err.Error())
+			continue
+		}
+
+		scheduled++
+	}
+
+	return scheduled
+}
+
 func (p *Plugin) constructMessageTemplate(userID, teamID string) *MessageTemplate {
-	data := &MessageTemplate{}
+	data := &MessageTemplate{lazy: p.newLazyTemplateData()}
 	var err *model.AppError
 
 	if len(userID) > 0 {
@@ -58,7 +142,7 @@ func (p *Plugin) getSiteURL() string {
 }
 
 func (p *Plugin) newSampleMessageTemplate(teamName string, userID string) (*MessageTemplate, error) {
-	data := &MessageTemplate{}
+	data := &MessageTemplate{lazy: p.newLazyTemplateData()}
 	var err *model.AppError
 
 	if data.User, err = p.API.GetUser(userID); err != nil {
@@ -92,9 +176,17 @@ func (p *Plugin) previewWelcomeMessage(teamName string, args *model.CommandArgs,
 		return err
 	}
 
-	post := p.renderWelcomeMessage(*messageTemplate, configMessage)
-	post.ChannelId = args.ChannelId
-	_ = p.API.SendEphemeralPost(args.UserId, post)
+	for _, variantMessage := range configMessage.getVariantMessages() {
+		if variantMessage.Variant != "" {
+			p.postCommandResponse(args, "Variant `%s` of welcome message `%s`:", variantMessage.Variant, variantMessage.ID)
+		}
+
+		for _, step := range variantMessage.getSteps() {
+			post := p.renderWelcomeMessage(*messageTemplate, variantMessage.forStep(step).localized(p.getLocaleFallbacks(messageTemplate.User)))
+			post.ChannelId = args.ChannelId
+			_ = p.API.SendEphemeralPost(args.UserId, post)
+		}
+	}
 
 	return nil
 }
@@ -112,7 +204,9 @@ func (p *Plugin) renderWelcomeMessage(messageTemplate MessageTemplate, configMes
 			action.Context.Action = "automatic"
 
 			for _, channelName := range configAction.ChannelsAddedTo {
-				p.joinChannel(action, channelName)
+				if channel := p.joinChannel(action, channelName); channel != nil {
+					messageTemplate.addJoinedChannel(channel)
+				}
 			}
 		}
 
@@ -121,9 +215,11 @@ func (p *Plugin) renderWelcomeMessage(messageTemplate MessageTemplate, configMes
 				Name: configAction.ActionDisplayName,
 				Integration: &model.PostActionIntegration{
 					Context: map[string]interface{}{
-						"action":  configAction.ActionName,
-						"team_id": messageTemplate.Team.Id,
-						"user_id": messageTemplate.User.Id,
+						"action":     configAction.ActionName,
+						"team_id":    messageTemplate.Team.Id,
+						"user_id":    messageTemplate.User.Id,
+						"message_id": configMessage.ID,
+						"variant":    configMessage.Variant,
 					},
 					URL: fmt.Sprintf("%v/plugins/%v/addchannels", p.getSiteURL(), manifest.Id),
 				},
@@ -133,34 +229,14 @@ func (p *Plugin) renderWelcomeMessage(messageTemplate MessageTemplate, configMes
 		}
 	}
 
-	tmpMsg, _ := template.New("Response").Parse(strings.Join(configMessage.Message, "\n"))
-	var message bytes.Buffer
-	err := tmpMsg.Execute(&message, messageTemplate)
-	if err != nil {
-		p.API.LogError(
-			"Failed to execute message template",
-			"err", err.Error(),
-		)
-	}
-
 	post := &model.Post{
-		Message: message.String(),
+		Message: p.executeTemplate("Response", configMessage.Message, messageTemplate),
 		UserId:  p.botUserID,
 	}
 
 	if len(configMessage.AttachmentMessage) > 0 || len(actionButtons) > 0 {
-		tmpAtch, _ := template.New("AttachmentResponse").Parse(strings.Join(configMessage.AttachmentMessage, "\n"))
-		var attachMessage bytes.Buffer
-		err := tmpAtch.Execute(&attachMessage, messageTemplate)
-		if err != nil {
-			p.API.LogError(
-				"Failed to execute message template",
-				"err", err.Error(),
-			)
-		}
-
 		sa1 := &model.SlackAttachment{
-			Text: attachMessage.String(),
+			Text: p.executeTemplate("AttachmentResponse", configMessage.AttachmentMessage, messageTemplate),
 		}
 
 		if len(actionButtons) > 0 {
@@ -177,9 +253,37 @@ func (p *Plugin) renderWelcomeMessage(messageTemplate MessageTemplate, configMes
 	return post
 }
 
-func (p *Plugin) processWelcomeMessage(messageTemplate MessageTemplate, configMessage ConfigMessage) {
-	time.Sleep(time.Second * time.Duration(configMessage.DelayInSeconds))
+// executeTemplate renders the lines of a message template. Errors are logged and rendered as an
+// empty message, as the configuration has been validated when loaded.
+func (p *Plugin) executeTemplate(name string, lines []string, data interface{}) string {
+	tmpl, err := p.getTemplate(name, lines)
+	if err != nil {
+		p.API.LogError(
+			"Failed to parse message template",
+			"err", err.Error(),
+		)
+		p.metrics.incTemplateErrors()
+		return ""
+	}
+	if tmpl == nil {
+		// The template failed to parse, which has been reported when loading the configuration
+		p.metrics.incTemplateErrors()
+		return ""
+	}
+
+	var message bytes.Buffer
+	if err := tmpl.Execute(&message, data); err != nil {
+		p.metrics.incTemplateErrors()
+		p.API.LogError(
+			"Failed to execute message template",
+			"err", err.Error(),
+		)
+	}
+
+	return message.String()
+}
 
+func (p *Plugin) processWelcomeMessage(messageTemplate MessageTemplate, configMessage ConfigMessage) error {
 	siteURL := p.getSiteURL()
 	if strings.Contains(siteURL, "localhost") || strings.Contains(siteURL, "127.0.0.1") {
 		p.API.LogWarn(`Site url is set to localhost or 127.0.0.1.  For this to work properly you must also set "AllowedUntrustedInternalConnections": "127.0.0.1" in config.json`)
@@ -188,32 +292,52 @@ func (p *Plugin) processWelcomeMessage(messageTemplate MessageTemplate, configMe
 	post := p.renderWelcomeMessage(messageTemplate, configMessage)
 	post.ChannelId = messageTemplate.DirectMessage.Id
 
-	if _, err := p.API.CreatePost(post); err != nil {
+	createdPost, err := p.API.CreatePost(post)
+	if err != nil {
 		p.API.LogError(
 			"We could not create the response post",
 			"user_id", post.UserId,
 			"err", err.Error(),
 		)
+		p.metrics.incWelcomesFailed(welcomeKindTeam)
+		p.updateStats(messageTemplate.Team.Id, func(stats *OnboardingStats) {
+			stats.WelcomesFailed++
+		})
+		return err
 	}
+
+	p.metrics.incWelcomesSent(welcomeKindTeam)
+	p.updateStats(messageTemplate.Team.Id, func(stats *OnboardingStats) {
+		stats.WelcomesSent++
+	})
+
+	p.recordHistoryEvent(messageTemplate.User.Id, &HistoryEvent{
+		Type:      historyEventWelcomeSent,
+		TeamID:    messageTemplate.Team.Id,
+		MessageID: configMessage.ID,
+		PostID:    createdPost.Id,
+	})
+
+	return nil
 }
 
 func (p *Plugin) processActionMessage(messageTemplate MessageTemplate, action *Action, configMessageAction ConfigMessageAction) {
-	for _, channelName := range configMessageAction.ChannelsAddedTo {
-		p.joinChannel(action, channelName)
-	}
+	p.recordHistoryEvent(messageTemplate.User.Id, &HistoryEvent{
+		Type:       historyEventActionTaken,
+		TeamID:     messageTemplate.Team.Id,
+		ActionName: configMessageAction.ActionName,
+	})
+	p.metrics.incActionClicks(configMessageAction.ActionName)
+	p.recordActionClick(messageTemplate.User.Id, messageTemplate.Team.Id, action.Context.MessageID, configMessageAction.ActionName)
 
-	tmpMsg, _ := template.New("Response").Parse(strings.Join(configMessageAction.ActionSuccessfulMessage, "\n"))
-	var message bytes.Buffer
-	err := tmpMsg.Execute(&message, messageTemplate)
-	if err != nil {
-		p.API.LogError(
-			"Failed to execute message template",
-			"err", err.Error(),
-		)
+	for _, channelName := range configMessageAction.ChannelsAddedTo {
+		if channel := p.joinChannel(action, channelName); channel != nil {
+			messageTemplate.addJoinedChannel(channel)
+		}
 	}
 
 	post := &model.Post{
-		Message:   message.String(),
+		Message:   p.executeTemplate("Response", configMessageAction.ActionSuccessfulMessage, messageTemplate),
 		ChannelId: messageTemplate.DirectMessage.Id,
 		UserId:    p.botUserID,
 	}
@@ -227,13 +351,27 @@ func (p *Plugin) processActionMessage(messageTemplate MessageTemplate, action *A
 	}
 }
 
-func (p *Plugin) joinChannel(action *Action, channelName string) {
+// joinChannel adds the user of the action to the channel, and returns the channel if successful
+func (p *Plugin) joinChannel(action *Action, channelName string) *model.Channel {
 	if channel, err := p.API.GetChannelByName(action.Context.TeamID, channelName, false); err == nil {
 		if _, err := p.API.AddChannelMember(channel.Id, action.Context.UserID); err != nil {
 			p.API.LogError("Couldn't add user to the channel, continuing to next channel", "user_id", action.Context.UserID, "channel_id", channel.Id)
-			return
+			p.metrics.incChannelJoinFailures()
+			return nil
 		}
-	} else {
-		p.API.LogError("failed to get channel, continuing to the next channel", "channel_name", channelName, "user_id", action.Context.UserID)
+
+		p.recordHistoryEvent(action.Context.UserID, &HistoryEvent{
+			Type:        historyEventChannelJoined,
+			TeamID:      action.Context.TeamID,
+			ActionName:  action.Context.Action,
+			ChannelID:   channel.Id,
+			ChannelName: channel.Name,
+		})
+
+		return channel
 	}
+
+	p.API.LogError("failed to get channel, continuing to the next channel", "channel_name", channelName, "user_id", action.Context.UserID)
+	p.metrics.incChannelJoinFailures()
+	return nil
 }